@@ -2,8 +2,10 @@ package log_v1
 
 import (
 	"fmt"
+	"strconv"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -19,8 +21,13 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 		Locale:  "en-US",
 		Message: msg,
 	}
+	info := &errdetails.ErrorInfo{
+		Reason:   errOffsetOutOfRangeReason,
+		Domain:   errDomain,
+		Metadata: map[string]string{offsetKey: fmt.Sprint(e.Offset)},
+	}
 
-	std, err := st.WithDetails(d)
+	std, err := st.WithDetails(d, info)
 	if err != nil {
 		return st
 	}
@@ -31,3 +38,158 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+const (
+	errOffsetOutOfRangeReason = "OFFSET_OUT_OF_RANGE"
+	offsetKey                 = "offset"
+)
+
+// AsErrOffsetOutOfRange extracts an ErrOffsetOutOfRange from the error of a
+// gRPC call, e.g. to restart a consumer at the lowest offset of the log.
+func AsErrOffsetOutOfRange(err error) (ErrOffsetOutOfRange, bool) {
+	info, ok := errorInfo(err, 404, errOffsetOutOfRangeReason)
+	if !ok {
+		return ErrOffsetOutOfRange{}, false
+	}
+	off, _ := strconv.ParseUint(info.Metadata[offsetKey], 10, 64)
+	return ErrOffsetOutOfRange{Offset: off}, true
+}
+
+type ErrCorruptRecord struct {
+	Offset uint64
+}
+
+func (e ErrCorruptRecord) GRPCStatus() *status.Status {
+	st := status.New(codes.DataLoss, fmt.Sprintf("corrupt record: %d", e.Offset))
+
+	msg := fmt.Sprintf("The record at offset %d failed its integrity check", e.Offset)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrCorruptRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrNotLeader is returned for writes to a server which isn't the leader.
+// LeaderAddr is empty while no leader is known.
+type ErrNotLeader struct {
+	LeaderAddr string
+}
+
+const (
+	errNotLeaderReason = "NOT_LEADER"
+	errDomain          = "proglog"
+	leaderAddrKey      = "leader_addr"
+)
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	st := status.New(codes.Unavailable, fmt.Sprintf("not the leader, leader: %q", e.LeaderAddr))
+
+	msg := "The server isn't the leader, retry the write at the leader"
+	if e.LeaderAddr != "" {
+		msg = fmt.Sprintf("The server isn't the leader, retry the write at %s", e.LeaderAddr)
+	}
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	info := &errdetails.ErrorInfo{
+		Reason:   errNotLeaderReason,
+		Domain:   errDomain,
+		Metadata: map[string]string{leaderAddrKey: e.LeaderAddr},
+	}
+
+	std, err := st.WithDetails(d, info)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// AsErrNotLeader extracts an ErrNotLeader from the error of a gRPC call, so
+// clients can retry writes at the leader.
+func AsErrNotLeader(err error) (ErrNotLeader, bool) {
+	info, ok := errorInfo(err, codes.Unavailable, errNotLeaderReason)
+	if !ok {
+		return ErrNotLeader{}, false
+	}
+	return ErrNotLeader{LeaderAddr: info.Metadata[leaderAddrKey]}, true
+}
+
+// ErrDraining ends the streams of a server which is taken out of service.
+// Clients resume them at another server, reading from ResumeOffset on.
+type ErrDraining struct {
+	ResumeOffset uint64
+}
+
+const (
+	errDrainingReason = "DRAINING"
+	resumeOffsetKey   = "resume_offset"
+)
+
+func (e ErrDraining) GRPCStatus() *status.Status {
+	st := status.New(codes.Unavailable, fmt.Sprintf("server is draining, resume at: %d", e.ResumeOffset))
+
+	msg := "The server is being taken out of service, retry at another server"
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	info := &errdetails.ErrorInfo{
+		Reason:   errDrainingReason,
+		Domain:   errDomain,
+		Metadata: map[string]string{resumeOffsetKey: fmt.Sprint(e.ResumeOffset)},
+	}
+
+	std, err := st.WithDetails(d, info)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrDraining) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// AsErrDraining extracts an ErrDraining from the error of a gRPC call, so
+// clients can resume streams at another server.
+func AsErrDraining(err error) (ErrDraining, bool) {
+	info, ok := errorInfo(err, codes.Unavailable, errDrainingReason)
+	if !ok {
+		return ErrDraining{}, false
+	}
+	off, _ := strconv.ParseUint(info.Metadata[resumeOffsetKey], 10, 64)
+	return ErrDraining{ResumeOffset: off}, true
+}
+
+// errorInfo returns the ErrorInfo detail of the gRPC error err if it has the
+// code and the reason.
+func errorInfo(err error, code codes.Code, reason string) (*errdetails.ErrorInfo, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != code {
+		return nil, false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Reason == reason && info.Domain == errDomain {
+			return info, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,95 @@
+// Package client connects to proglog clusters. Its Producer batches records
+// into CreateStream and retries them at the leader, its Consumer tails the log
+// with GetStream and resumes where it stopped once its stream breaks.
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Config configures the connection of a Client.
+type Config struct {
+	// TLSConfig secures the connections to the servers. They are insecure
+	// if it is nil.
+	TLSConfig *tls.Config
+	// Zone is the zone of the client. Reads prefer the followers labeled
+	// with the same zone.
+	Zone string
+	// MaxLag excludes followers which were out of contact with the leader
+	// for longer from reads. It defaults to the lag the servers' balancer
+	// allows.
+	MaxLag time.Duration
+	// MaxLagEntries excludes followers which are further behind the
+	// committed entries of the leader from reads, with the same default.
+	MaxLagEntries uint64
+}
+
+// Client is a connection to the servers of a cluster. Writes go to the leader
+// and reads to the followers, following the servers as they change.
+type Client struct {
+	conn  *grpc.ClientConn
+	log   api.LogClient
+	admin api.AdminClient
+}
+
+// Dial connects to the cluster of the server at addr.
+func Dial(addr string, config Config) (*Client, error) {
+	lbConfig := loadbalance.DefaultConfig
+	lbConfig.Zone = config.Zone
+	if config.MaxLag > 0 {
+		lbConfig.MaxLagMs = uint64(config.MaxLag.Milliseconds())
+	}
+	if config.MaxLagEntries > 0 {
+		lbConfig.MaxLagEntries = config.MaxLagEntries
+	}
+
+	creds := insecure.NewCredentials()
+	if config.TLSConfig != nil {
+		creds = credentials.NewTLS(config.TLSConfig)
+	}
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", loadbalance.Name, addr),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithResolvers(&loadbalance.Resolver{Config: &lbConfig}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// New creates a Client using conn, e.g. a connection to a single server. The
+// Client takes over conn and closes it with Close.
+func New(conn *grpc.ClientConn) *Client {
+	return &Client{
+		conn:  conn,
+		log:   api.NewLogClient(conn),
+		admin: api.NewAdminClient(conn),
+	}
+}
+
+// Log returns the client of the Log service, e.g. for calls the Producer and
+// the Consumer don't cover.
+func (c *Client) Log() api.LogClient {
+	return c.log
+}
+
+// Admin returns the client of the Admin service. Its calls go to the leader
+// of connections made by Dial.
+func (c *Client) Admin() api.AdminClient {
+	return c.admin
+}
+
+// Close closes the connection. Producers and Consumers of the Client must be
+// closed before.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal"
+	"github.com/justagabriel/proglog/internal/agent"
+	"github.com/justagabriel/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+const host = "localhost"
+
+// setupAgents starts a cluster of an agent per zone, the first one being its
+// leader, and waits until all of them joined.
+func setupAgents(t *testing.T, zones ...string) []*agent.Agent {
+	t.Helper()
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: host,
+		Server:        true,
+	})
+	require.NoError(t, err)
+	peerTLSConfig := clientTLSConfig(t, config.RootClientCertFile, config.RootClientKeyFile)
+
+	var agents []*agent.Agent
+	for i, zone := range zones {
+		var startJoinAddrs []string
+		if i != 0 {
+			startJoinAddrs = append(startJoinAddrs, agents[0].Config.BindAddr)
+		}
+		a, err := agent.New(agent.Config{
+			ServerTLSConfig: serverTLSConfig,
+			PeerTLSConfig:   peerTLSConfig,
+			DataDir:         internal.GetTempDir(t, "client-test-log"),
+			BindAddr:        fmt.Sprintf("%s:%d", host, internal.FreePort(t)),
+			RPCPort:         internal.FreePort(t),
+			NodeName:        fmt.Sprintf("%d", i),
+			StartJoinAddr:   startJoinAddrs,
+			ACLModelFile:    config.ACLModelFile,
+			ACLPolicyFile:   config.ACLPolicyFile,
+			Bootstrap:       i == 0,
+			Labels:          map[string]string{"zone": zone},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, a.Shutdown())
+			require.NoError(t, os.RemoveAll(a.Config.DataDir))
+		})
+		agents = append(agents, a)
+	}
+
+	c := dial(t, agents[0], Config{})
+	require.Eventually(t, func() bool {
+		res, err := c.Log().GetServers(context.Background(), &api.GetServersRequest{})
+		if err != nil || len(res.Servers) != len(zones) {
+			return false
+		}
+		for _, server := range res.Servers {
+			if server.IsLeader {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 100*time.Millisecond)
+	return agents
+}
+
+func clientTLSConfig(t *testing.T, certFile, keyFile string) *tls.Config {
+	t.Helper()
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: host,
+	})
+	require.NoError(t, err)
+	return tlsConfig
+}
+
+// dial connects to the cluster of a as root unless the config has a
+// TLSConfig.
+func dial(t *testing.T, a *agent.Agent, config Config) *Client {
+	t.Helper()
+	if config.TLSConfig == nil {
+		config.TLSConfig = a.Config.PeerTLSConfig
+	}
+	rpcAddr, err := a.Config.RPCAddr()
+	require.NoError(t, err)
+	c, err := Dial(rpcAddr, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+)
+
+// ConsumerConfig configures the buffering and the reconnects of a Consumer.
+// Zero values take the defaults.
+type ConsumerConfig struct {
+	// MaxBuffered is the most records received ahead of Next. Defaults to
+	// 100.
+	MaxBuffered int
+	// RetryBackoff is the wait before reconnecting a broken stream, which
+	// doubles while reconnects fail up to MaxRetryBackoff. Defaults to 100ms
+	// and 5s.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func (c *ConsumerConfig) setDefaults() {
+	if c.MaxBuffered <= 0 {
+		c.MaxBuffered = 100
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 100 * time.Millisecond
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = 5 * time.Second
+	}
+}
+
+// Consumer reads the records of the log in order, waiting for new ones at its
+// end. It tails the log with a GetStream, which it reopens at the next offset
+// once the stream breaks, e.g. because its server is drained.
+type Consumer struct {
+	config  ConsumerConfig
+	log     api.LogClient
+	records chan *api.Record
+	cancel  context.CancelFunc
+	done    chan struct{}
+	// err ends the records once they are closed
+	err error
+
+	mu     sync.Mutex
+	offset uint64
+}
+
+// NewConsumer creates a Consumer reading from offset on, e.g. from the Offset
+// a previous Consumer stored.
+func (c *Client) NewConsumer(offset uint64, config ConsumerConfig) *Consumer {
+	config.setDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := &Consumer{
+		config:  config,
+		log:     c.log,
+		records: make(chan *api.Record, config.MaxBuffered),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		offset:  offset,
+	}
+	go consumer.run(ctx, offset)
+	return consumer
+}
+
+// Next returns the next record, waiting until it is appended. It fails with
+// ErrOffsetOutOfRange if the record was removed from the log, ErrClosed once
+// the Consumer is closed or other errors which reconnecting can't resolve.
+func (c *Consumer) Next(ctx context.Context) (*api.Record, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case record, ok := <-c.records:
+		if !ok {
+			return nil, c.err
+		}
+		c.mu.Lock()
+		c.offset = record.Offset + 1
+		c.mu.Unlock()
+		return record, nil
+	}
+}
+
+// Offset returns the offset following the last record returned by Next. A
+// Consumer created at it continues where this one stopped.
+func (c *Consumer) Offset() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+// Close stops the Consumer.
+func (c *Consumer) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *Consumer) run(ctx context.Context, offset uint64) {
+	defer close(c.done)
+	defer close(c.records)
+
+	backoff := c.config.RetryBackoff
+	for {
+		next, err := c.consume(ctx, offset)
+		if ctx.Err() != nil {
+			c.err = ErrClosed
+			return
+		}
+		if !retryable(err) {
+			c.err = fromStatus(err)
+			return
+		}
+		// the stream resumes after the last record it received, which is
+		// also the offset draining servers tell to resume at
+		if next > offset {
+			offset, backoff = next, c.config.RetryBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			c.err = ErrClosed
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, c.config.MaxRetryBackoff)
+	}
+}
+
+// consume streams the records from offset on into the records until the
+// stream breaks and returns the offset following the last one.
+func (c *Consumer) consume(ctx context.Context, offset uint64) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.log.GetStream(ctx)
+	if err != nil {
+		return offset, err
+	}
+	err = stream.Send(&api.GetRecordRequest{Offset: offset})
+	if err == io.EOF {
+		// the stream broke, its status comes with Recv
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		return offset, err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return offset, err
+		}
+		select {
+		case <-ctx.Done():
+			return offset, ctx.Err()
+		case c.records <- res.Record:
+			offset = res.Record.Offset + 1
+		}
+	}
+}
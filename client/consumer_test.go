@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+func TestConsumer(t *testing.T) {
+	// arrange
+	agents := setupAgents(t, "a", "b", "c")
+	c := dial(t, agents[0], Config{})
+	producer := c.NewProducer(ProducerConfig{})
+	defer producer.Close()
+	produce(t, producer, 0, 3)
+
+	// act
+	consumer := c.NewConsumer(1, ConsumerConfig{})
+	defer consumer.Close()
+
+	// assert
+	consume(t, consumer, 1, 3)
+	// the consumer waits for records at the end of the log
+	produce(t, producer, 3, 4)
+	consume(t, consumer, 3, 4)
+	require.Equal(t, uint64(4), consumer.Offset())
+
+	require.NoError(t, consumer.Close())
+	_, err := consumer.Next(context.Background())
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestConsumerResumesAfterShutdown(t *testing.T) {
+	// arrange
+	agents := setupAgents(t, "a", "b", "c")
+	c := dial(t, agents[0], Config{Zone: "b"})
+	producer := c.NewProducer(ProducerConfig{})
+	defer producer.Close()
+	produce(t, producer, 0, 3)
+	// reads prefer the follower in the zone of the client
+	consumer := c.NewConsumer(0, ConsumerConfig{RetryBackoff: 10 * time.Millisecond})
+	defer consumer.Close()
+	consume(t, consumer, 0, 3)
+
+	// act
+	// the follower drains its stream and leaves the cluster
+	require.NoError(t, agents[1].Shutdown())
+	produce(t, producer, 3, 6)
+
+	// assert
+	consume(t, consumer, 3, 6)
+}
+
+func TestConsumerFailsForRemovedRecords(t *testing.T) {
+	// arrange
+	c := &Client{log: failingLog{err: api.ErrOffsetOutOfRange{Offset: 2}}}
+	consumer := c.NewConsumer(2, ConsumerConfig{})
+	defer consumer.Close()
+
+	// act
+	_, err := consumer.Next(context.Background())
+
+	// assert
+	require.Equal(t, ErrOffsetOutOfRange{Offset: 2}, err)
+}
+
+// failingLog fails to open streams with err.
+type failingLog struct {
+	api.LogClient
+	err error
+}
+
+func (l failingLog) GetStream(context.Context, ...grpc.CallOption) (api.Log_GetStreamClient, error) {
+	return nil, status.Convert(l.err).Err()
+}
+
+// produce appends the records with the values from to to.
+func produce(t *testing.T, producer *Producer, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		offset, err := producer.Produce(context.Background(), &api.Record{Value: []byte(fmt.Sprint(i))})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), offset)
+	}
+}
+
+// consume reads the records produced with the values from to to.
+func consume(t *testing.T, consumer *Consumer, from, to int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := from; i < to; i++ {
+		record, err := consumer.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), record.Offset)
+		require.Equal(t, []byte(fmt.Sprint(i)), record.Value)
+	}
+}
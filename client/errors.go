@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+	"io"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClosed is returned by Producers and Consumers once they are closed.
+var ErrClosed = errors.New("client closed")
+
+// The errors of the servers which clients handle are returned as these types,
+// so they can be told apart with errors.As.
+type (
+	// ErrNotLeader is returned for writes which failed at a server that
+	// isn't the leader.
+	ErrNotLeader = api.ErrNotLeader
+	// ErrDraining is returned for streams of a server that is taken out
+	// of service.
+	ErrDraining = api.ErrDraining
+	// ErrOffsetOutOfRange is returned for reads of offsets which were
+	// removed from the log.
+	ErrOffsetOutOfRange = api.ErrOffsetOutOfRange
+)
+
+// fromStatus converts the status errors of the servers to their types and
+// returns other errors as they are.
+func fromStatus(err error) error {
+	if e, ok := api.AsErrNotLeader(err); ok {
+		return e
+	}
+	if e, ok := api.AsErrDraining(err); ok {
+		return e
+	}
+	if e, ok := api.AsErrOffsetOutOfRange(err); ok {
+		return e
+	}
+	return err
+}
+
+// retryable reports whether a call which failed with err may succeed at
+// another attempt. Unavailable covers lost connections and leaders, elections
+// and draining servers.
+func retryable(err error) bool {
+	return err == io.EOF || status.Code(err) == codes.Unavailable
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromStatus(t *testing.T) {
+	other := status.Error(codes.PermissionDenied, "denied")
+	for name, tc := range map[string]struct {
+		err       error
+		want      error
+		retryable bool
+	}{
+		"not leader": {
+			err:       api.ErrNotLeader{LeaderAddr: "localhost:8400"},
+			want:      ErrNotLeader{LeaderAddr: "localhost:8400"},
+			retryable: true,
+		},
+		"draining": {
+			err:       api.ErrDraining{ResumeOffset: 3},
+			want:      ErrDraining{ResumeOffset: 3},
+			retryable: true,
+		},
+		"offset out of range": {
+			err:  api.ErrOffsetOutOfRange{Offset: 5},
+			want: ErrOffsetOutOfRange{Offset: 5},
+		},
+		"other": {
+			err:  other,
+			want: other,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// the errors arrive as statuses
+			err := status.Convert(tc.err).Err()
+
+			require.Equal(t, tc.want, fromStatus(err))
+			require.Equal(t, tc.retryable, retryable(err))
+		})
+	}
+
+	var notLeader ErrNotLeader
+	require.True(t, errors.As(fromStatus(status.Convert(api.ErrNotLeader{}).Err()), &notLeader))
+}
@@ -0,0 +1,304 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+)
+
+// ProducerConfig configures the batching and the retries of a Producer. Zero
+// values take the defaults.
+type ProducerConfig struct {
+	// BatchSize is the most records sent at once. Defaults to 100.
+	BatchSize int
+	// Linger is how long a batch waits for more records before it is sent.
+	// Zero sends the records which are waiting right away.
+	Linger time.Duration
+	// MaxBuffered is the most records waiting to be sent. Send blocks once
+	// they are reached. Defaults to 1000.
+	MaxBuffered int
+	// MaxRetries is how often a batch is retried after it failed with a
+	// retryable error, e.g. because the leader changed. Defaults to 10.
+	MaxRetries int
+	// NoRetries fails batches with their first error instead of retrying
+	// them, regardless of MaxRetries.
+	NoRetries bool
+	// RetryBackoff is the wait before the first retry, which doubles with
+	// each further one up to MaxRetryBackoff. Defaults to 100ms and 5s.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func (c *ProducerConfig) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.MaxBuffered <= 0 {
+		c.MaxBuffered = 1000
+	}
+	if c.NoRetries {
+		c.MaxRetries = 0
+	} else if c.MaxRetries <= 0 {
+		c.MaxRetries = 10
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 100 * time.Millisecond
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = 5 * time.Second
+	}
+}
+
+// Producer appends records to the log. It sends them in batches over a
+// CreateStream to the leader and retries them once the stream fails.
+// Records which were appended before the stream failed but weren't
+// acknowledged are appended again, so the Producer delivers at least once.
+type Producer struct {
+	config ProducerConfig
+	log    api.LogClient
+
+	mu     sync.RWMutex
+	closed bool
+	acks   chan *Ack
+	done   chan struct{}
+
+	stream api.Log_CreateStreamClient
+	cancel context.CancelFunc
+}
+
+// NewProducer creates a Producer appending records to the cluster of c.
+func (c *Client) NewProducer(config ProducerConfig) *Producer {
+	config.setDefaults()
+	p := &Producer{
+		config: config,
+		log:    c.log,
+		acks:   make(chan *Ack, config.MaxBuffered),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Ack is the acknowledgement of a record handed to Send. It is done once the
+// record was appended or failed.
+type Ack struct {
+	// record is nil for the acks of flushes
+	record *api.Record
+	done   chan struct{}
+	offset uint64
+	err    error
+}
+
+// Done is closed once the record was appended or failed.
+func (a *Ack) Done() <-chan struct{} {
+	return a.done
+}
+
+// Wait waits until the record was appended and returns its offset.
+func (a *Ack) Wait(ctx context.Context) (uint64, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-a.done:
+		return a.offset, a.err
+	}
+}
+
+func (a *Ack) complete(offset uint64, err error) {
+	a.offset, a.err = offset, err
+	close(a.done)
+}
+
+// Send hands record to the Producer, blocking while MaxBuffered records wait
+// to be sent. The returned Ack tells when and at which offset it was appended.
+func (p *Producer) Send(ctx context.Context, record *api.Record) (*Ack, error) {
+	return p.enqueue(ctx, &Ack{record: record, done: make(chan struct{})})
+}
+
+// Produce appends record and returns its offset.
+func (p *Producer) Produce(ctx context.Context, record *api.Record) (uint64, error) {
+	ack, err := p.Send(ctx, record)
+	if err != nil {
+		return 0, err
+	}
+	return ack.Wait(ctx)
+}
+
+// Flush sends the waiting records right away and waits until the records
+// handed to Send before are done.
+func (p *Producer) Flush(ctx context.Context) error {
+	ack, err := p.enqueue(ctx, &Ack{done: make(chan struct{})})
+	if err != nil {
+		return err
+	}
+	_, err = ack.Wait(ctx)
+	return err
+}
+
+func (p *Producer) enqueue(ctx context.Context, ack *Ack) (*Ack, error) {
+	// the read lock keeps Close from closing acks while it is sent to
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case p.acks <- ack:
+		return ack, nil
+	}
+}
+
+// Close sends the waiting records and stops the Producer once they are done.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.acks)
+	}
+	p.mu.Unlock()
+	<-p.done
+	return nil
+}
+
+func (p *Producer) run() {
+	defer close(p.done)
+	defer p.closeStream()
+	for {
+		batch, ok := p.nextBatch()
+		if !ok {
+			return
+		}
+		p.send(batch)
+	}
+}
+
+// nextBatch waits for a record and collects the following ones until the
+// batch is full, it lingered long enough or a flush asks for it.
+func (p *Producer) nextBatch() ([]*Ack, bool) {
+	ack, ok := <-p.acks
+	if !ok {
+		return nil, false
+	}
+	batch := []*Ack{ack}
+
+	var linger <-chan time.Time
+	if p.config.Linger > 0 {
+		timer := time.NewTimer(p.config.Linger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+	for ack.record != nil && len(batch) < p.config.BatchSize {
+		if linger == nil {
+			select {
+			case ack, ok = <-p.acks:
+			default:
+				return batch, true
+			}
+		} else {
+			select {
+			case ack, ok = <-p.acks:
+			case <-linger:
+				return batch, true
+			}
+		}
+		if !ok {
+			break
+		}
+		batch = append(batch, ack)
+	}
+	return batch, true
+}
+
+// send appends the records of batch, retrying the ones which weren't
+// acknowledged, and then completes the flushes of the batch.
+func (p *Producer) send(batch []*Ack) {
+	var pending, flushes []*Ack
+	for _, ack := range batch {
+		if ack.record == nil {
+			flushes = append(flushes, ack)
+		} else {
+			pending = append(pending, ack)
+		}
+	}
+
+	backoff := p.config.RetryBackoff
+	for retries := 0; len(pending) > 0; {
+		acked, err := p.sendOnce(pending)
+		pending = pending[acked:]
+		if err == nil {
+			continue
+		}
+		if acked > 0 {
+			retries, backoff = 0, p.config.RetryBackoff
+		}
+		if !retryable(err) || retries >= p.config.MaxRetries {
+			err = fromStatus(err)
+			for _, ack := range pending {
+				ack.complete(0, err)
+			}
+			break
+		}
+		retries++
+		time.Sleep(backoff)
+		backoff = min(2*backoff, p.config.MaxRetryBackoff)
+	}
+
+	for _, ack := range flushes {
+		ack.complete(0, nil)
+	}
+}
+
+// sendOnce sends the records of acks over the stream and returns how many
+// were acknowledged. The stream is closed on errors, so the next call opens a
+// new one, e.g. at the new leader.
+func (p *Producer) sendOnce(acks []*Ack) (int, error) {
+	if p.stream == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := p.log.CreateStream(ctx)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		p.stream, p.cancel = stream, cancel
+	}
+
+	acked, err := p.exchange(acks)
+	if err != nil {
+		p.closeStream()
+	}
+	return acked, err
+}
+
+func (p *Producer) exchange(acks []*Ack) (int, error) {
+	for _, ack := range acks {
+		err := p.stream.Send(&api.CreateRecordRequest{Record: ack.record})
+		if err == io.EOF {
+			// the stream broke, its status comes with Recv
+			_, err = p.stream.Recv()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	// the server answers in the order of the records
+	for i, ack := range acks {
+		res, err := p.stream.Recv()
+		if err != nil {
+			return i, err
+		}
+		ack.complete(res.Offset, nil)
+	}
+	return len(acks), nil
+}
+
+func (p *Producer) closeStream() {
+	if p.stream != nil {
+		p.cancel()
+		p.stream, p.cancel = nil, nil
+	}
+}
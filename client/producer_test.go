@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProducer(t *testing.T) {
+	// arrange
+	agents := setupAgents(t, "a", "b", "c")
+	c := dial(t, agents[0], Config{})
+	producer := c.NewProducer(ProducerConfig{BatchSize: 10, Linger: 10 * time.Millisecond})
+	defer producer.Close()
+
+	// act
+	var acks []*Ack
+	for i := 0; i < 25; i++ {
+		ack, err := producer.Send(context.Background(), &api.Record{Value: []byte(fmt.Sprint(i))})
+		require.NoError(t, err)
+		acks = append(acks, ack)
+	}
+	require.NoError(t, producer.Flush(context.Background()))
+
+	// assert
+	for i, ack := range acks {
+		select {
+		case <-ack.Done():
+		default:
+			require.Fail(t, "flush returned before the ack was done", "ack %d", i)
+		}
+		offset, err := ack.Wait(context.Background())
+		require.NoError(t, err)
+		res, err := c.Log().Get(context.Background(), &api.GetRecordRequest{
+			Offset:      offset,
+			Consistency: api.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprint(i)), res.Record.Value)
+	}
+}
+
+func TestProducerRetriesAtNewLeader(t *testing.T) {
+	// arrange
+	agents := setupAgents(t, "a", "b", "c")
+	c := dial(t, agents[1], Config{})
+	producer := c.NewProducer(ProducerConfig{RetryBackoff: 50 * time.Millisecond})
+	defer producer.Close()
+	first, err := producer.Produce(context.Background(), &api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+
+	// act
+	// draining the leader ends the stream and hands leadership on
+	agents[0].Drain()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	second, err := producer.Produce(ctx, &api.Record{Value: []byte("second")})
+
+	// assert
+	require.NoError(t, err)
+	require.Greater(t, second, first)
+}
+
+func TestProducerFailsWithoutPermission(t *testing.T) {
+	// arrange
+	agents := setupAgents(t, "a")
+	c := dial(t, agents[0], Config{
+		TLSConfig: clientTLSConfig(t, config.NobodyClientCertFile, config.NobodyClientKeyFile),
+	})
+	producer := c.NewProducer(ProducerConfig{})
+	defer producer.Close()
+
+	// act
+	_, err := producer.Produce(context.Background(), &api.Record{Value: []byte("foo")})
+
+	// assert
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestProducerBackpressure(t *testing.T) {
+	// arrange
+	release := make(chan struct{})
+	c := &Client{log: blockingLog{release: release}}
+	producer := c.NewProducer(ProducerConfig{MaxBuffered: 1})
+	defer producer.Close()
+	defer close(release)
+
+	// the first record blocks the producer, the second fills its buffer
+	_, err := producer.Send(context.Background(), &api.Record{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(producer.acks) == 0 }, time.Second, time.Millisecond)
+	_, err = producer.Send(context.Background(), &api.Record{})
+	require.NoError(t, err)
+
+	// act
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = producer.Send(ctx, &api.Record{})
+
+	// assert
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestProducerWithoutRetries(t *testing.T) {
+	// arrange
+	log := &unavailableLog{}
+	c := &Client{log: log}
+	producer := c.NewProducer(ProducerConfig{NoRetries: true, RetryBackoff: time.Millisecond})
+	defer producer.Close()
+
+	// act
+	_, err := producer.Produce(context.Background(), &api.Record{})
+
+	// assert
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, int32(1), log.calls.Load())
+}
+
+// unavailableLog fails to open streams with a retryable error.
+type unavailableLog struct {
+	api.LogClient
+	calls atomic.Int32
+}
+
+func (l *unavailableLog) CreateStream(context.Context, ...grpc.CallOption) (api.Log_CreateStreamClient, error) {
+	l.calls.Add(1)
+	return nil, status.Error(codes.Unavailable, "unavailable")
+}
+
+// blockingLog fails to open streams once it is released.
+type blockingLog struct {
+	api.LogClient
+	release chan struct{}
+}
+
+func (l blockingLog) CreateStream(context.Context, ...grpc.CallOption) (api.Log_CreateStreamClient, error) {
+	<-l.release
+	return nil, status.Error(codes.PermissionDenied, "released")
+}
@@ -2,22 +2,29 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
 	"github.com/justagabriel/proglog/internal/auth"
 	"github.com/justagabriel/proglog/internal/discovery"
 	"github.com/justagabriel/proglog/internal/log"
+	"github.com/justagabriel/proglog/internal/metrics"
 	"github.com/justagabriel/proglog/internal/server"
 	"github.com/soheilhy/cmux"
+	"go.opencensus.io/stats/view"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Agent encapsulates all components of a Node.
@@ -26,7 +33,9 @@ type Agent struct {
 
 	mux        cmux.CMux
 	log        *log.DistributedLog
-	server     *grpc.Server
+	server     *server.GRPCServer
+	httpServer *http.Server
+	drain      *server.Drain
 	membership *discovery.Membership
 
 	shutdown     bool
@@ -46,6 +55,44 @@ type Config struct {
 	ACLModelFile    string
 	ACLPolicyFile   string
 	Bootstrap       bool
+	// RetentionMaxAge and RetentionMaxBytes limit the data kept by the log,
+	// zero values keep everything.
+	RetentionMaxAge   time.Duration
+	RetentionMaxBytes uint64
+	// CompactionInterval enables the key-based compaction of the log,
+	// TombstoneRetention delays the removal of tombstones.
+	CompactionInterval time.Duration
+	TombstoneRetention time.Duration
+	// SyncMode is the durability policy of the log, one of "os", "always"
+	// and "group". SyncInterval and SyncBytes bound the group commits.
+	SyncMode     string
+	SyncInterval time.Duration
+	SyncBytes    uint64
+	// MaxOpenSegments bounds the sealed segments with open files.
+	MaxOpenSegments int
+	// Role is either "voter", the default, or "nonvoter" for read replicas
+	// which don't count towards the quorum.
+	Role string
+	// Labels describe the topology of the node, e.g. its zone and rack. They
+	// are gossiped to the cluster and served to clients with the servers.
+	Labels map[string]string
+	// MaxApplyLag is the number of committed raft entries the node may not
+	// have applied yet while its health service reports it as serving.
+	MaxApplyLag uint64
+	// JWT enables bearer tokens signed with its keys, which take precedence
+	// over client certificates. Without key files tokens aren't accepted.
+	JWT auth.JWTConfig
+	// URISANPrefix authenticates callers by the URI SANs of their client
+	// certificates with this prefix, e.g. their SPIFFE IDs, ahead of their
+	// common names.
+	URISANPrefix string
+	// DenyAnonymous rejects the callers no authenticator identifies, which
+	// are otherwise authorized as AnonymousSubject.
+	DenyAnonymous    bool
+	AnonymousSubject string
+	// WebSocketOrigins are the origins of the pages, besides the node
+	// itself, allowed to tail the log over WebSocket.
+	WebSocketOrigins []string
 }
 
 // RPCAddr returns the URI of the Agent client.
@@ -140,7 +187,15 @@ func (a *Agent) setupLog() error {
 	logConfig.Raft.BindAddr = rpcAddr
 	logConfig.Raft.LocalID = raft.ServerID(a.Config.NodeName)
 	logConfig.Raft.Bootstrap = a.Config.Bootstrap
-	logConfig.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	logConfig.Raft.MaxApplyLag = a.Config.MaxApplyLag
+	logConfig.Retention.MaxAge = a.Config.RetentionMaxAge
+	logConfig.Retention.MaxBytes = a.Config.RetentionMaxBytes
+	logConfig.Compaction.Interval = a.Config.CompactionInterval
+	logConfig.Compaction.TombstoneRetention = a.Config.TombstoneRetention
+	logConfig.Segment.Sync = log.SyncMode(a.Config.SyncMode)
+	logConfig.Segment.SyncInterval = a.Config.SyncInterval
+	logConfig.Segment.SyncBytes = a.Config.SyncBytes
+	logConfig.Segment.MaxOpenSegments = a.Config.MaxOpenSegments
 	a.log, err = log.NewDistributedLog(
 		a.Config.DataDir,
 		logConfig,
@@ -154,16 +209,55 @@ func (a *Agent) setupLog() error {
 	return err
 }
 
+// authenticator chains the authenticators enabled by the config: bearer
+// tokens, URI SANs, common names and anonymous callers.
+func (a *Agent) authenticator() (server.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	if a.Config.JWT.HMACKeyFile != "" || a.Config.JWT.RSAKeyFile != "" {
+		jwt, err := auth.NewJWT(a.Config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	if a.Config.URISANPrefix != "" {
+		authenticators = append(authenticators, auth.URISAN{Prefix: a.Config.URISANPrefix})
+	}
+	authenticators = append(authenticators, auth.CommonName{})
+	if !a.Config.DenyAnonymous {
+		authenticators = append(authenticators, auth.Anonymous(a.Config.AnonymousSubject))
+	}
+	return auth.Chain(authenticators...), nil
+}
+
 func (a *Agent) setupServer() error {
+	a.drain = server.NewDrain()
 	authorizer, err := auth.New(a.Config.ACLModelFile, a.Config.ACLPolicyFile)
 	if err != nil {
 		return err
 	}
 
+	// writes reaching a follower are forwarded to the leader as a peer
+	forwardCreds := insecure.NewCredentials()
+	if a.Config.PeerTLSConfig != nil {
+		forwardCreds = credentials.NewTLS(a.Config.PeerTLSConfig)
+	}
 	serverConfig := &server.Config{
-		CommitLog:   a.log,
-		Authorizer:  authorizer,
-		GetServerer: a.log,
+		CommitLog:          a.log,
+		Authorizer:         authorizer,
+		GetServerer:        a.log,
+		ForwardDialOptions: []grpc.DialOption{grpc.WithTransportCredentials(forwardCreds)},
+		Cluster:            a.log,
+		Drain:              a.drain,
+		WebSocketOrigins:   a.Config.WebSocketOrigins,
+	}
+	serverConfig.Authenticator, err = a.authenticator()
+	if err != nil {
+		return err
+	}
+	serverConfig.Metrics, err = a.metricsHandler()
+	if err != nil {
+		return err
 	}
 
 	var opts []grpc.ServerOption
@@ -177,6 +271,17 @@ func (a *Agent) setupServer() error {
 		return err
 	}
 
+	a.httpServer = server.NewHTTPServer(a.server)
+
+	// the HTTP/JSON gateway is matched ahead of gRPC, which takes the rest
+	var httpLn net.Listener
+	if a.Config.ServerTLSConfig != nil {
+		tlsConfig := a.Config.ServerTLSConfig.Clone()
+		tlsConfig.NextProtos = []string{httpProto}
+		httpLn = tls.NewListener(a.mux.Match(offersHTTP), tlsConfig)
+	} else {
+		httpLn = a.mux.Match(cmux.HTTP1Fast())
+	}
 	grpcLn := a.mux.Match(cmux.Any())
 
 	go func() {
@@ -184,10 +289,31 @@ func (a *Agent) setupServer() error {
 			_ = a.Shutdown()
 		}
 	}()
+	go func() {
+		if err := a.httpServer.Serve(httpLn); err != http.ErrServerClosed {
+			_ = a.Shutdown()
+		}
+	}()
 
 	return err
 }
 
+// metricsHandler serves the gRPC server views along with the latencies of
+// the log and gauges of the log, raft and serf.
+func (a *Agent) metricsHandler() (http.Handler, error) {
+	if err := view.Register(log.Views...); err != nil {
+		return nil, err
+	}
+	return metrics.Handler(metrics.NewProducer(metrics.Config{
+		Log:  a.log,
+		Raft: a.log,
+		Members: func() []serf.Member {
+			// the membership is set up last, before the agent serves
+			return a.membership.Members()
+		},
+	})), nil
+}
+
 func (a *Agent) setupMembership() error {
 	rpcAddr, err := a.Config.RPCAddr()
 	if err != nil {
@@ -200,12 +326,22 @@ func (a *Agent) setupMembership() error {
 			"rpc_addr": rpcAddr,
 		},
 		StartJoinAddrs: a.Config.StartJoinAddr,
+		Role:           discovery.Role(a.Config.Role),
+		Labels:         a.Config.Labels,
 	}
 	a.membership, err = discovery.New(a.log, discoveryConfig)
 	return err
 }
 
-// Shutdown free's up all resources hold by this Agent.
+// Drain takes the agent out of service ahead of a shutdown. It rejects new
+// streams, hands the raft leadership to a follower and ends the active streams,
+// while the agent keeps serving all other requests.
+func (a *Agent) Drain() {
+	a.drain.Run(a.log)
+}
+
+// Shutdown free's up all resources hold by this Agent. It drains the agent
+// first, so the cluster doesn't wait for an election timeout to replace it.
 func (a *Agent) Shutdown() error {
 	a.shutdownLock.Lock()
 	defer a.shutdownLock.Unlock()
@@ -217,11 +353,23 @@ func (a *Agent) Shutdown() error {
 	close(a.shutdowns)
 
 	shutdownFuncs := []func() error{
+		func() error {
+			a.Drain()
+			return nil
+		},
 		a.membership.Leave,
 		func() error {
 			a.server.GracefulStop()
 			return nil
 		},
+		func() error {
+			err := a.httpServer.Shutdown(context.Background())
+			if errors.Is(err, net.ErrClosed) {
+				// gRPC closed the listener both share through the mux
+				return nil
+			}
+			return err
+		},
 		a.log.Close,
 	}
 
@@ -4,6 +4,8 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"testing"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func TestAgent(t *testing.T) {
@@ -80,6 +83,14 @@ func TestAgent(t *testing.T) {
 
 	time.Sleep(3 * time.Second)
 
+	// the leader keeps its lease across heartbeats instead of stepping down
+	for i := 0; i < 20; i++ {
+		servers, err := agents[0].log.GetServers()
+		require.NoError(t, err)
+		require.True(t, servers[0].IsLeader, "leader stepped down")
+		time.Sleep(50 * time.Millisecond)
+	}
+
 	leaderClient := client(t, agents[0], peerTLSConfig)
 	createReq := api.CreateRecordRequest{
 		Record: &api.Record{
@@ -114,6 +125,34 @@ func TestAgent(t *testing.T) {
 	got := status.Code(err)
 	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
 	require.Equal(t, got, want)
+
+	// the HTTP/JSON gateway shares the port with gRPC
+	rpcAddr, err := agents[1].Config.RPCAddr()
+	require.NoError(t, err)
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: peerTLSConfig}}
+	defer httpClient.CloseIdleConnections()
+	httpResp, err := httpClient.Get(fmt.Sprintf("https://%s/v1/records/%d", rpcAddr, createResp.Offset))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	require.Equal(t, http.StatusOK, httpResp.StatusCode)
+	body, err := io.ReadAll(httpResp.Body)
+	require.NoError(t, err)
+	httpGetResp := &api.GetRecordResponse{}
+	require.NoError(t, protojson.Unmarshal(body, httpGetResp))
+	require.Equal(t, createReq.Record.Value, httpGetResp.Record.Value)
+
+	// as does the metrics endpoint
+	metricsResp, err := httpClient.Get(fmt.Sprintf("https://%s/metrics", rpcAddr))
+	require.NoError(t, err)
+	defer metricsResp.Body.Close()
+	require.Equal(t, http.StatusOK, metricsResp.StatusCode)
+	body, err = io.ReadAll(metricsResp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `proglog_raft_state{state="Follower"} 1`)
+	require.Contains(t, string(body), `proglog_serf_members{status="alive"} 3`)
+	require.Contains(t, string(body), "proglog_log_highest_offset 0")
+	require.Contains(t, string(body), "proglog_log_append_latency_count")
+	require.Contains(t, string(body), "grpc_io_server_completed_rpcs")
 }
 
 func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.LogClient {
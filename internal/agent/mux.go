@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// httpProto is the ALPN protocol of HTTP/1.1 and grpcProto the one of
+// HTTP/2, which gRPC clients offer exclusively.
+const (
+	httpProto = "http/1.1"
+	grpcProto = "h2"
+)
+
+var errHelloRead = errors.New("client hello read")
+
+// offersHTTP matches TLS connections of HTTP/1.1 clients. TLS hides the
+// requests from the mux, but the protocols offered by the client hello tell
+// HTTP clients, which offer HTTP/1.1 or nothing at all, apart from gRPC
+// clients, which only offer h2.
+func offersHTTP(r io.Reader) bool {
+	var hello *tls.ClientHelloInfo
+	conn := tls.Server(readOnlyConn{r}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloRead
+		},
+	})
+	_ = conn.Handshake()
+	if hello == nil {
+		return false
+	}
+	return slices.Contains(hello.SupportedProtos, httpProto) ||
+		!slices.Contains(hello.SupportedProtos, grpcProto)
+}
+
+// readOnlyConn lets a TLS server read the client hello of a connection without
+// responding to it.
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)     { return c.r.Read(b) }
+func (readOnlyConn) Write([]byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (readOnlyConn) Close() error                     { return nil }
+func (readOnlyConn) LocalAddr() net.Addr              { return nil }
+func (readOnlyConn) RemoteAddr() net.Addr             { return nil }
+func (readOnlyConn) SetDeadline(time.Time) error      { return nil }
+func (readOnlyConn) SetReadDeadline(time.Time) error  { return nil }
+func (readOnlyConn) SetWriteDeadline(time.Time) error { return nil }
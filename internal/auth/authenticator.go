@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Credentials are what a caller presents to a server.
+type Credentials struct {
+	// TLS is the state of the caller's TLS connection, nil without TLS.
+	TLS *tls.ConnectionState
+	// Authorization are the values of the authorization metadata of a gRPC
+	// request, or of the Authorization header of an HTTP request.
+	Authorization []string
+}
+
+// An Authenticator returns the subject of a caller, which is then authorized
+// by the Authorizer. Authenticators fail with ErrNoCredentials if the caller
+// didn't present credentials they understand, and with an Unauthenticated
+// status if the credentials are invalid.
+type Authenticator interface {
+	Authenticate(ctx context.Context, creds Credentials) (string, error)
+}
+
+// ErrNoCredentials is returned by authenticators for callers without
+// credentials they understand.
+var ErrNoCredentials = status.Error(codes.Unauthenticated, "no credentials")
+
+// Chain returns an Authenticator trying authenticators in order, until one
+// of them finds credentials it understands. Callers none of them
+// authenticate fail with ErrNoCredentials, unless the chain ends with
+// Anonymous.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+type chain []Authenticator
+
+func (c chain) Authenticate(ctx context.Context, creds Credentials) (string, error) {
+	for _, a := range c {
+		subject, err := a.Authenticate(ctx, creds)
+		if err != ErrNoCredentials {
+			return subject, err
+		}
+	}
+	return "", ErrNoCredentials
+}
+
+// Anonymous authenticates all callers as subject, e.g. "" for the callers
+// no other authenticator of a chain knows, so the policy grants them the
+// actions of "".
+type Anonymous string
+
+func (a Anonymous) Authenticate(context.Context, Credentials) (string, error) {
+	return string(a), nil
+}
+
+// CommonName authenticates callers by the common name of their verified
+// client certificate.
+type CommonName struct{}
+
+func (CommonName) Authenticate(_ context.Context, creds Credentials) (string, error) {
+	cert := verifiedCert(creds.TLS)
+	if cert == nil {
+		return "", ErrNoCredentials
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// URISAN authenticates callers by the first URI SAN of their verified client
+// certificate which starts with Prefix, e.g. "spiffe://example.org/" for the
+// SPIFFE IDs of a trust domain. The subject is the whole URI.
+type URISAN struct {
+	Prefix string
+}
+
+func (a URISAN) Authenticate(_ context.Context, creds Credentials) (string, error) {
+	cert := verifiedCert(creds.TLS)
+	if cert == nil {
+		return "", ErrNoCredentials
+	}
+	for _, uri := range cert.URIs {
+		if s := uri.String(); strings.HasPrefix(s, a.Prefix) {
+			return s, nil
+		}
+	}
+	return "", ErrNoCredentials
+}
+
+// verifiedCert returns the verified client certificate of state, or nil for
+// connections without one.
+func verifiedCert(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthenticators(t *testing.T) {
+	// arrange
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "root"},
+		URIs: []*url.URL{
+			{Scheme: "https", Host: "example.org"},
+			{Scheme: "spiffe", Host: "example.org", Path: "/ns/prod/sa/producer"},
+		},
+	}
+	withCert := Credentials{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	withoutCert := Credentials{TLS: &tls.ConnectionState{}}
+	bearer := Credentials{Authorization: []string{"Bearer token"}}
+
+	tests := map[string]struct {
+		authenticator Authenticator
+		creds         Credentials
+		subject       string
+		err           error
+	}{
+		"common name": {
+			authenticator: CommonName{},
+			creds:         withCert,
+			subject:       "root",
+		},
+		"common name without certificate": {
+			authenticator: CommonName{},
+			creds:         withoutCert,
+			err:           ErrNoCredentials,
+		},
+		"uri san": {
+			authenticator: URISAN{Prefix: "spiffe://example.org/"},
+			creds:         withCert,
+			subject:       "spiffe://example.org/ns/prod/sa/producer",
+		},
+		"uri san of another trust domain": {
+			authenticator: URISAN{Prefix: "spiffe://example.com/"},
+			creds:         withCert,
+			err:           ErrNoCredentials,
+		},
+		"chain tries authenticators in order": {
+			authenticator: Chain(URISAN{Prefix: "spiffe://"}, CommonName{}),
+			creds:         withCert,
+			subject:       "spiffe://example.org/ns/prod/sa/producer",
+		},
+		"chain falls back": {
+			authenticator: Chain(URISAN{Prefix: "spiffe://example.com/"}, CommonName{}),
+			creds:         withCert,
+			subject:       "root",
+		},
+		"chain without anonymous": {
+			authenticator: Chain(CommonName{}),
+			creds:         bearer,
+			err:           ErrNoCredentials,
+		},
+		"chain with anonymous": {
+			authenticator: Chain(CommonName{}, Anonymous("anonymous")),
+			creds:         bearer,
+			subject:       "anonymous",
+		},
+		"chain stops at invalid credentials": {
+			authenticator: Chain(failing{}, Anonymous("")),
+			creds:         bearer,
+			err:           status.Error(codes.Unauthenticated, "invalid"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// act
+			subject, err := tt.authenticator.Authenticate(context.Background(), tt.creds)
+
+			// assert
+			if tt.err != nil {
+				require.Equal(t, status.Code(tt.err), status.Code(err))
+				require.Equal(t, tt.err.Error(), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.subject, subject)
+		})
+	}
+}
+
+// failing rejects all credentials as invalid.
+type failing struct{}
+
+func (failing) Authenticate(context.Context, Credentials) (string, error) {
+	return "", status.Error(codes.Unauthenticated, "invalid")
+}
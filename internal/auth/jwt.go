@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// JWTConfig configures the validation of JWT bearer tokens. At least one of
+// the key files must be set.
+type JWTConfig struct {
+	// HMACKeyFile holds the secret of tokens signed with HS256, HS384 or
+	// HS512. Trailing newlines aren't part of the secret.
+	HMACKeyFile string
+	// RSAKeyFile holds the PEM encoded public key, or a certificate of it,
+	// of tokens signed with RS256, RS384 or RS512.
+	RSAKeyFile string
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when the exp and nbf claims are checked.
+	Leeway time.Duration
+}
+
+// JWT authenticates callers by the sub claim of their bearer tokens, which
+// are passed as "Bearer <token>" in the authorization metadata of gRPC
+// requests or the Authorization header of HTTP requests. Tokens must expire.
+type JWT struct {
+	config  JWTConfig
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+	now     func() time.Time
+}
+
+func NewJWT(config JWTConfig) (*JWT, error) {
+	a := &JWT{config: config, now: time.Now}
+	if config.HMACKeyFile == "" && config.RSAKeyFile == "" {
+		return nil, errors.New("jwt: no key file")
+	}
+	if config.HMACKeyFile != "" {
+		b, err := os.ReadFile(config.HMACKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.hmacKey = bytes.TrimRight(b, "\r\n")
+		if len(a.hmacKey) == 0 {
+			return nil, fmt.Errorf("jwt: empty key in %q", config.HMACKeyFile)
+		}
+	}
+	if config.RSAKeyFile != "" {
+		var err error
+		if a.rsaKey, err = readRSAPublicKey(config.RSAKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func readRSAPublicKey(file string) (*rsa.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: no PEM data in %q", file)
+	}
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("jwt: unexpected %s in %q", block.Type, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt: %q doesn't hold an RSA key", file)
+	}
+	return rsaKey, nil
+}
+
+func (a *JWT) Authenticate(_ context.Context, creds Credentials) (string, error) {
+	token, ok := bearerToken(creds.Authorization)
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return "", status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	return claims.Subject, nil
+}
+
+func bearerToken(authorization []string) (string, bool) {
+	for _, value := range authorization {
+		scheme, token, ok := strings.Cut(value, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), true
+		}
+	}
+	return "", false
+}
+
+// jwtHashes are the hashes of the supported algorithms, whose first two
+// letters tell HMAC and RSA apart.
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+}
+
+// jwtAudience is either a single audience or an array of them.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// verify checks the signature and the claims of token. The algorithm of the
+// token has to match the type of a key, so tokens signed with the public RSA
+// key as an HMAC secret are rejected.
+func (a *JWT) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	hash, ok := jwtHashes[header.Alg]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	case strings.HasPrefix(header.Alg, "HS") && a.hmacKey != nil:
+		mac := hmac.New(hash.New, a.hmacKey)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("invalid signature")
+		}
+	case strings.HasPrefix(header.Alg, "RS") && a.rsaKey != nil:
+		h := hash.New()
+		h.Write(signed)
+		if err := rsa.VerifyPKCS1v15(a.rsaKey, hash, h.Sum(nil), sig); err != nil {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	claims := &jwtClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	now := a.now()
+	switch {
+	case claims.ExpiresAt == nil:
+		return nil, errors.New("missing exp claim")
+	case now.After(unixTime(*claims.ExpiresAt).Add(a.config.Leeway)):
+		return nil, errors.New("token expired")
+	case claims.NotBefore != nil && now.Before(unixTime(*claims.NotBefore).Add(-a.config.Leeway)):
+		return nil, errors.New("token not valid yet")
+	case a.config.Issuer != "" && claims.Issuer != a.config.Issuer:
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case a.config.Audience != "" && !slices.Contains(claims.Audience, a.config.Audience):
+		return nil, errors.New("unexpected audience")
+	case claims.Subject == "":
+		return nil, errors.New("missing sub claim")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// unixTime returns the time of a NumericDate, which may have fractions of
+// seconds.
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestJWT(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	hmacKey := []byte("secret")
+	hmacKeyFile := filepath.Join(dir, "hmac.key")
+	require.NoError(t, os.WriteFile(hmacKeyFile, append(hmacKey, '\n'), 0o600))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	rsaKeyFile := filepath.Join(dir, "rsa.pem")
+	require.NoError(t, os.WriteFile(rsaKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	authenticator, err := NewJWT(JWTConfig{
+		HMACKeyFile: hmacKeyFile,
+		RSAKeyFile:  rsaKeyFile,
+		Issuer:      "proglog",
+		Audience:    "log",
+		Leeway:      time.Minute,
+	})
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	authenticator.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub": "root",
+			"iss": "proglog",
+			"aud": []string{"log", "admin"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := map[string]struct {
+		token   string
+		subject string
+		err     string
+	}{
+		"HS256": {
+			token:   signHMAC(t, "HS256", hmacKey, claims(nil)),
+			subject: "root",
+		},
+		"HS512 with a single audience": {
+			token:   signHMAC(t, "HS512", hmacKey, claims(map[string]any{"aud": "log"})),
+			subject: "root",
+		},
+		"RS256": {
+			token:   signRSA(t, "RS256", rsaKey, claims(nil)),
+			subject: "root",
+		},
+		"bad signature": {
+			token: signHMAC(t, "HS256", []byte("other"), claims(nil)),
+			err:   "invalid signature",
+		},
+		"public key as hmac secret": {
+			token: signHMAC(t, "HS256", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), claims(nil)),
+			err:   "invalid signature",
+		},
+		"none algorithm": {
+			token: encodeToken(t, "none", claims(nil)) + ".",
+			err:   `unsupported algorithm "none"`,
+		},
+		"expired": {
+			token: signHMAC(t, "HS256", hmacKey, claims(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()})),
+			err:   "token expired",
+		},
+		"expired within leeway": {
+			token:   signHMAC(t, "HS256", hmacKey, claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()})),
+			subject: "root",
+		},
+		"without expiry": {
+			token: signHMAC(t, "HS256", hmacKey, claims(map[string]any{"exp": nil})),
+			err:   "missing exp claim",
+		},
+		"not valid yet": {
+			token: signHMAC(t, "HS256", hmacKey, claims(map[string]any{"nbf": now.Add(time.Hour).Unix()})),
+			err:   "token not valid yet",
+		},
+		"other issuer": {
+			token: signHMAC(t, "HS256", hmacKey, claims(map[string]any{"iss": "other"})),
+			err:   `unexpected issuer "other"`,
+		},
+		"other audience": {
+			token: signHMAC(t, "HS256", hmacKey, claims(map[string]any{"aud": "other"})),
+			err:   "unexpected audience",
+		},
+		"malformed": {
+			token: "not-a-token",
+			err:   "malformed token",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// act
+			subject, err := authenticator.Authenticate(context.Background(), Credentials{
+				Authorization: []string{"Bearer " + tt.token},
+			})
+
+			// assert
+			if tt.err != "" {
+				require.Equal(t, codes.Unauthenticated, status.Code(err))
+				require.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.subject, subject)
+		})
+	}
+
+	t.Run("without bearer token", func(t *testing.T) {
+		_, err := authenticator.Authenticate(context.Background(), Credentials{
+			Authorization: []string{"Basic cm9vdDpyb290"},
+		})
+		require.Equal(t, ErrNoCredentials, err)
+	})
+}
+
+func TestNewJWT(t *testing.T) {
+	_, err := NewJWT(JWTConfig{})
+	require.Error(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "hmac.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("\n"), 0o600))
+	_, err = NewJWT(JWTConfig{HMACKeyFile: keyFile})
+	require.Error(t, err)
+
+	_, err = NewJWT(JWTConfig{RSAKeyFile: keyFile})
+	require.Error(t, err)
+}
+
+func encodeToken(t *testing.T, alg string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func signHMAC(t *testing.T, alg string, key []byte, claims map[string]any) string {
+	t.Helper()
+	signed := encodeToken(t, alg, claims)
+	mac := hmac.New(jwtHashes[alg].New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRSA(t *testing.T, alg string, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	signed := encodeToken(t, alg, claims)
+	hash := jwtHashes[alg]
+	h := hash.New()
+	h.Write([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil))
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
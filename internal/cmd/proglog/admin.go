@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/client"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func serversCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "servers",
+		Short: "Print the servers of the cluster.",
+		Args:  cobra.NoArgs,
+		RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+			return c.Log().GetServers(ctx, &api.GetServersRequest{})
+		}),
+	}
+	setupClientFlags(cmd.Flags())
+	return cmd
+}
+
+// adminCommand groups the calls of the Admin service. Calls to proglog://
+// addresses go to the leader.
+func adminCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Manage the raft cluster and the log of a server.",
+	}
+	setupClientFlags(cmd.PersistentFlags())
+
+	var voter bool
+	addPeer := &cobra.Command{
+		Use:   "add-peer <id> <rpc-addr>",
+		Short: "Add a server to the raft configuration.",
+		Args:  cobra.ExactArgs(2),
+		RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+			suffrage := api.Suffrage_SUFFRAGE_VOTER
+			if !voter {
+				suffrage = api.Suffrage_SUFFRAGE_NONVOTER
+			}
+			return c.Admin().AddPeer(ctx, &api.AddPeerRequest{Id: args[0], RpcAddr: args[1], Suffrage: suffrage})
+		}),
+	}
+	addPeer.Flags().BoolVar(&voter, "voter", true, "Whether the server votes, false adds a read replica.")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "raft-state",
+			Short: "Print the raft state of the server.",
+			Args:  cobra.NoArgs,
+			RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+				return c.Admin().GetRaftState(ctx, &api.GetRaftStateRequest{})
+			}),
+		},
+		addPeer,
+		&cobra.Command{
+			Use:   "remove-peer <id>",
+			Short: "Remove a server from the raft configuration.",
+			Args:  cobra.ExactArgs(1),
+			RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+				return c.Admin().RemovePeer(ctx, &api.RemovePeerRequest{Id: args[0]})
+			}),
+		},
+		&cobra.Command{
+			Use:   "transfer-leadership [id]",
+			Short: "Hand the leadership to the server, or one raft picks.",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+				req := &api.TransferLeadershipRequest{}
+				if len(args) > 0 {
+					req.Id = args[0]
+				}
+				return c.Admin().TransferLeadership(ctx, req)
+			}),
+		},
+		&cobra.Command{
+			Use:   "snapshot",
+			Short: "Snapshot the raft state of the server.",
+			Args:  cobra.NoArgs,
+			RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+				return c.Admin().Snapshot(ctx, &api.SnapshotRequest{})
+			}),
+		},
+		&cobra.Command{
+			Use:   "segments",
+			Short: "Print the segments of the server's log.",
+			Args:  cobra.NoArgs,
+			RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+				return c.Admin().GetSegments(ctx, &api.GetSegmentsRequest{})
+			}),
+		},
+		&cobra.Command{
+			Use:   "drain",
+			Short: "Take the server out of service ahead of a restart.",
+			Args:  cobra.NoArgs,
+			RunE: call(func(ctx context.Context, c *client.Client, args []string) (proto.Message, error) {
+				return c.Admin().Drain(ctx, &api.DrainRequest{})
+			}),
+		},
+	)
+	return cmd
+}
+
+// call returns the RunE of a command making a single call and printing its
+// response as JSON. Empty responses print nothing.
+func call(fn func(ctx context.Context, c *client.Client, args []string) (proto.Message, error)) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		c, err := dial(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		ctx, cancel := signalContext()
+		defer cancel()
+		res, err := fn(ctx, c, args)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cmd.Name(), err)
+		}
+		if proto.Size(res) == 0 {
+			return nil
+		}
+		return printJSON(cmd.OutOrStdout(), res)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/client"
+	"github.com/justagabriel/proglog/internal/config"
+	"github.com/justagabriel/proglog/internal/loadbalance"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The output formats of the commands printing records.
+const (
+	outputRaw  = "raw"
+	outputJSON = "json"
+)
+
+// setupClientFlags adds the flags connecting to a server, which mirror the
+// server's --server-tls-* flags.
+func setupClientFlags(flags *pflag.FlagSet) {
+	flags.String("addr", "localhost:8400", fmt.Sprintf("Server address, %s://host:port spreads the calls across the cluster of the server.", loadbalance.Name))
+	flags.String("client-tls-cert-file", "", "Path to client tls cert.")
+	flags.String("client-tls-key-file", "", "Path to client tls key.")
+	flags.String("client-tls-ca-file", "", "Path to client certificate authority, connections are insecure without it.")
+	flags.String("client-tls-server-name", "", "Name of the server to verify its certificate against, defaults to the host of --addr.")
+}
+
+// dial connects to the server or, for proglog:// addresses, the cluster of
+// the --addr flag.
+func dial(cmd *cobra.Command) (*client.Client, error) {
+	flags := cmd.Flags()
+	addr, _ := flags.GetString("addr")
+	tlsConfig := config.TLSConfig{}
+	tlsConfig.CertFile, _ = flags.GetString("client-tls-cert-file")
+	tlsConfig.KeyFile, _ = flags.GetString("client-tls-key-file")
+	tlsConfig.CAFile, _ = flags.GetString("client-tls-ca-file")
+	tlsConfig.ServerAddress, _ = flags.GetString("client-tls-server-name")
+
+	creds := insecure.NewCredentials()
+	if tlsConfig.CAFile != "" {
+		c, err := config.SetupTLSConfig(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(c)
+	}
+
+	scheme := loadbalance.Name + "://"
+	if strings.HasPrefix(addr, scheme) {
+		// accept proglog://host:port for the target proglog:///host:port
+		addr = scheme + "/" + strings.TrimPrefix(strings.TrimPrefix(addr, scheme), "/")
+	}
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return client.New(conn), nil
+}
+
+// signalContext returns a context which is done once the command is
+// interrupted.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+func setupOutputFlag(flags *pflag.FlagSet) {
+	flags.StringP("output", "o", outputRaw, "Output format of the records: \"raw\" prints their values, \"json\" the records.")
+}
+
+// recordPrinter returns a function printing records in the format of the
+// --output flag.
+func recordPrinter(cmd *cobra.Command) (func(*api.Record) error, error) {
+	output, _ := cmd.Flags().GetString("output")
+	w := cmd.OutOrStdout()
+	switch output {
+	case outputRaw:
+		return func(record *api.Record) error {
+			_, err := fmt.Fprintf(w, "%s\n", record.Value)
+			return err
+		}, nil
+	case outputJSON:
+		return func(record *api.Record) error {
+			return printJSON(w, record)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown output %q", output)
+	}
+}
+
+// printJSON prints m as a line of JSON.
+func printJSON(w io.Writer, m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func openFile(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/client"
+	"github.com/spf13/cobra"
+)
+
+func consumeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "consume",
+		Short: "Print the records of an offset range.",
+		RunE:  runConsume,
+	}
+	setupClientFlags(cmd.Flags())
+	setupOutputFlag(cmd.Flags())
+	cmd.Flags().Uint64("from", 0, "Offset of the first record, records removed from the log are skipped.")
+	cmd.Flags().Uint64("to", 0, "Offset following the last record, defaults to the end of the log.")
+	cmd.Flags().BoolP("follow", "f", false, "Wait for new records at the end of the log instead of stopping there, unless --to is set.")
+	return cmd
+}
+
+func tailCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Print the last records of the log.",
+		RunE:  runTail,
+	}
+	setupClientFlags(cmd.Flags())
+	setupOutputFlag(cmd.Flags())
+	cmd.Flags().Uint64P("lines", "n", 10, "Number of records to print.")
+	cmd.Flags().BoolP("follow", "f", false, "Wait for new records at the end of the log.")
+	return cmd
+}
+
+func runConsume(cmd *cobra.Command, args []string) error {
+	from, _ := cmd.Flags().GetUint64("from")
+	to, _ := cmd.Flags().GetUint64("to")
+	follow, _ := cmd.Flags().GetBool("follow")
+	bounded := cmd.Flags().Changed("to")
+	return consume(cmd, func(lowest, end uint64) (uint64, uint64, bool) {
+		if !bounded && !follow {
+			to, bounded = end, true
+		}
+		return max(from, lowest), to, bounded
+	})
+}
+
+func runTail(cmd *cobra.Command, args []string) error {
+	lines, _ := cmd.Flags().GetUint64("lines")
+	follow, _ := cmd.Flags().GetBool("follow")
+	return consume(cmd, func(lowest, end uint64) (uint64, uint64, bool) {
+		from := lowest
+		if end > lowest+lines {
+			from = end - lines
+		}
+		return from, end, !follow
+	})
+}
+
+// consume prints the records of the range returned by bounds, given the
+// lowest offset of the log and the offset following its last record. Unless
+// the range is bounded by to, the log is followed until the command is
+// interrupted.
+func consume(cmd *cobra.Command, bounds func(lowest, end uint64) (from, to uint64, bounded bool)) error {
+	printRecord, err := recordPrinter(cmd)
+	if err != nil {
+		return err
+	}
+	c, err := dial(cmd)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	lowest, end, err := logRange(ctx, c)
+	if err != nil {
+		return err
+	}
+	from, to, bounded := bounds(lowest, end)
+	if bounded && from >= to {
+		return nil
+	}
+
+	consumer := c.NewConsumer(from, client.ConsumerConfig{})
+	defer consumer.Close()
+	for {
+		record, err := consumer.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if bounded && record.Offset >= to {
+			return nil
+		}
+		if err := printRecord(record); err != nil {
+			return err
+		}
+		if bounded && record.Offset+1 >= to {
+			return nil
+		}
+	}
+}
+
+// logRange returns the lowest offset of the log and the offset following its
+// last record.
+func logRange(ctx context.Context, c *client.Client) (lowest, end uint64, err error) {
+	res, err := c.Log().GetOffsets(ctx, &api.GetOffsetsRequest{})
+	if err != nil {
+		return 0, 0, err
+	}
+	if res.HighestOffset == 0 {
+		// the highest offset of empty logs is 0 as well
+		_, err := c.Log().Get(ctx, &api.GetRecordRequest{
+			Offset:      0,
+			Consistency: api.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE,
+		})
+		if _, ok := api.AsErrOffsetOutOfRange(err); ok {
+			return res.LowestOffset, 0, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return res.LowestOffset, res.HighestOffset + 1, nil
+}
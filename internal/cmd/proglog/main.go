@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path"
 	"syscall"
+	"time"
 
 	"github.com/justagabriel/proglog/internal/agent"
 	"github.com/justagabriel/proglog/internal/config"
@@ -18,12 +19,23 @@ func main() {
 	cli := &cli{}
 	cmd := &cobra.Command{
 		Use:     "proglog",
+		Short:   "Run a proglog server, or call one with the subcommands.",
 		PreRunE: cli.setupConfig,
 		RunE:    cli.run,
+		// errors are logged below, without the usage
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 	if err := setupFlags(cmd); err != nil {
 		log.Fatal(err)
 	}
+	cmd.AddCommand(
+		produceCommand(),
+		consumeCommand(),
+		tailCommand(),
+		serversCommand(),
+		adminCommand(),
+	)
 	if err := cmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
@@ -55,10 +67,33 @@ func setupFlags(cmd *cobra.Command) error {
 	cmd.Flags().Int("rpc-port", 8400, "Port for RPC clients (and Raft) connections.")
 	cmd.Flags().StringSlice("start-join-addrs", nil, "Serf addresses to join.")
 	cmd.Flags().Bool("bootstrap", false, "Bootstrap the cluster.")
+	cmd.Flags().String("role", "voter", "Raft role of the node: \"voter\" or \"nonvoter\" for read replicas.")
+	cmd.Flags().StringToString("labels", nil, "Topology labels of the node, e.g. zone=eu-west-1a,rack=r1.")
+	cmd.Flags().Uint64("max-apply-lag", 1000, "Committed raft entries the node may have left to apply while it reports to be healthy.")
+
+	cmd.Flags().Duration("retention-max-age", 0, "Remove records older than this duration, 0 keeps them forever.")
+	cmd.Flags().Uint64("retention-max-bytes", 0, "Remove the oldest records once the log exceeds this size, 0 disables the limit.")
+	cmd.Flags().Duration("compaction-interval", 0, "Keep only the newest record per key, compacting at this interval. 0 disables compaction.")
+	cmd.Flags().Duration("tombstone-retention", 24*time.Hour, "Keep tombstones for this duration before compaction removes them.")
+
+	cmd.Flags().String("sync-mode", "os", "When records are fsynced: \"always\", \"group\" or \"os\" to leave it to the operating system.")
+	cmd.Flags().Duration("sync-interval", 0, "Fsync interval of the \"group\" sync mode.")
+	cmd.Flags().Uint64("sync-bytes", 0, "Fsync once this many bytes are pending in the \"group\" sync mode.")
+	cmd.Flags().Int("max-open-segments", 64, "Maximum number of sealed log segments with open files.")
 
 	cmd.Flags().String("acl-model-file", "", "Path to ACL model.")
 	cmd.Flags().String("acl-policy-file", "", "Path to ACL policy.")
 
+	cmd.Flags().String("jwt-hmac-key-file", "", "Path to the secret of HMAC-signed JWT bearer tokens.")
+	cmd.Flags().String("jwt-rsa-key-file", "", "Path to the PEM public key, or certificate, of RSA-signed JWT bearer tokens.")
+	cmd.Flags().String("jwt-issuer", "", "Required iss claim of JWT bearer tokens.")
+	cmd.Flags().String("jwt-audience", "", "Required aud claim of JWT bearer tokens.")
+	cmd.Flags().Duration("jwt-leeway", time.Minute, "Clock skew allowed when the expiry of JWT bearer tokens is checked.")
+	cmd.Flags().String("uri-san-prefix", "", "Authenticate clients by the URI SANs of their certificates with this prefix, e.g. spiffe://example.org/.")
+	cmd.Flags().Bool("deny-anonymous", false, "Reject clients without credentials instead of authorizing them as the anonymous subject.")
+	cmd.Flags().String("anonymous-subject", "", "ACL subject of clients without credentials.")
+	cmd.Flags().StringSlice("websocket-origins", nil, "Origins of the web pages allowed to tail the log over WebSocket besides the server itself, \"*\" allows all.")
+
 	cmd.Flags().String("server-tls-cert-file", "", "Path to server tls cert.")
 	cmd.Flags().String("server-tls-key-file", "", "Path to server tls key.")
 	cmd.Flags().String("server-tls-ca-file", "", "Path to server certificate authority.")
@@ -90,10 +125,31 @@ func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
 	c.cfg.BindAddr = viper.GetString("bind-addr")
 	c.cfg.StartJoinAddr = viper.GetStringSlice("start-join-addrs")
 	c.cfg.Bootstrap = viper.GetBool("bootstrap")
+	c.cfg.Role = viper.GetString("role")
+	c.cfg.Labels = viper.GetStringMapString("labels")
+	c.cfg.MaxApplyLag = viper.GetUint64("max-apply-lag")
+	c.cfg.RetentionMaxAge = viper.GetDuration("retention-max-age")
+	c.cfg.RetentionMaxBytes = viper.GetUint64("retention-max-bytes")
+	c.cfg.CompactionInterval = viper.GetDuration("compaction-interval")
+	c.cfg.TombstoneRetention = viper.GetDuration("tombstone-retention")
+	c.cfg.SyncMode = viper.GetString("sync-mode")
+	c.cfg.SyncInterval = viper.GetDuration("sync-interval")
+	c.cfg.SyncBytes = viper.GetUint64("sync-bytes")
+	c.cfg.MaxOpenSegments = viper.GetInt("max-open-segments")
 
 	c.cfg.ACLModelFile = viper.GetString("acl-model-file")
 	c.cfg.ACLPolicyFile = viper.GetString("acl-policy-file")
 
+	c.cfg.JWT.HMACKeyFile = viper.GetString("jwt-hmac-key-file")
+	c.cfg.JWT.RSAKeyFile = viper.GetString("jwt-rsa-key-file")
+	c.cfg.JWT.Issuer = viper.GetString("jwt-issuer")
+	c.cfg.JWT.Audience = viper.GetString("jwt-audience")
+	c.cfg.JWT.Leeway = viper.GetDuration("jwt-leeway")
+	c.cfg.URISANPrefix = viper.GetString("uri-san-prefix")
+	c.cfg.DenyAnonymous = viper.GetBool("deny-anonymous")
+	c.cfg.AnonymousSubject = viper.GetString("anonymous-subject")
+	c.cfg.WebSocketOrigins = viper.GetStringSlice("websocket-origins")
+
 	c.cfg.ServerTLSConfig.CertFile = viper.GetString("server-tls-cert-file")
 	c.cfg.ServerTLSConfig.KeyFile = viper.GetString("server-tls-key-file")
 	c.cfg.ServerTLSConfig.CAFile = viper.GetString("server-tls-ca-file")
@@ -127,7 +183,10 @@ func (c *cli) run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
-	<-sigc
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
+	// SIGUSR1 drains the agent ahead of a restart
+	for sig := <-sigc; sig == syscall.SIGUSR1; sig = <-sigc {
+		agent.Drain()
+	}
 	return agent.Shutdown()
 }
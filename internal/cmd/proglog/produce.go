@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/client"
+	"github.com/spf13/cobra"
+)
+
+func produceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "produce [file...]",
+		Short: "Append the lines of the files or stdin as records and print their offsets.",
+		RunE:  runProduce,
+	}
+	setupClientFlags(cmd.Flags())
+	cmd.Flags().String("key-separator", "", "Split lines into the key and the value of their records at the first separator.")
+	cmd.Flags().Int("batch-size", 100, "Maximum number of records sent at once.")
+	cmd.Flags().Duration("linger", 0, "How long batches wait for more records before they are sent.")
+	cmd.Flags().Int("max-retries", 10, "How often failed batches are retried, e.g. after leader changes. 0 disables retries.")
+	return cmd
+}
+
+func runProduce(cmd *cobra.Command, args []string) error {
+	c, err := dial(cmd)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	config := client.ProducerConfig{}
+	config.BatchSize, _ = cmd.Flags().GetInt("batch-size")
+	config.Linger, _ = cmd.Flags().GetDuration("linger")
+	config.MaxRetries, _ = cmd.Flags().GetInt("max-retries")
+	config.NoRetries = config.MaxRetries == 0
+	separator, _ := cmd.Flags().GetString("key-separator")
+	producer := c.NewProducer(config)
+	defer producer.Close()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	// the offsets are printed in the order of the lines while the producer
+	// keeps sending
+	acks := make(chan *client.Ack, config.BatchSize)
+	printed := make(chan error, 1)
+	go func() {
+		var err error
+		for ack := range acks {
+			var offset uint64
+			if err == nil {
+				offset, err = ack.Wait(ctx)
+			}
+			if err == nil {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), offset)
+			}
+		}
+		printed <- err
+	}()
+
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	err = produceFiles(args, separator, func(record *api.Record) error {
+		ack, err := producer.Send(ctx, record)
+		if err == nil {
+			acks <- ack
+		}
+		return err
+	})
+	close(acks)
+	if printErr := <-printed; err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// produceFiles sends a record per line of the files, "-" reading stdin.
+func produceFiles(names []string, separator string, send func(*api.Record) error) error {
+	for _, name := range names {
+		f, err := openFile(name)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() && err == nil {
+			record := &api.Record{Value: []byte(scanner.Text())}
+			if separator != "" {
+				if key, value, ok := strings.Cut(scanner.Text(), separator); ok {
+					record.Key, record.Value = []byte(key), []byte(value)
+				}
+			}
+			err = send(record)
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
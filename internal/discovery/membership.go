@@ -2,7 +2,9 @@ package discovery
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"strings"
 
 	"github.com/hashicorp/raft"
 	"github.com/hashicorp/serf/serf"
@@ -10,15 +12,41 @@ import (
 )
 
 type Handler interface {
-	Join(name, addr string) error
+	// Join adds a member to the cluster, as a voter or as a replica which
+	// doesn't count towards the quorum.
+	Join(name, addr string, voter bool) error
 	Leave(name string) error
 }
 
+// Labeler is implemented by Handlers which keep the labels of the members,
+// e.g. to tell clients the zones of the servers. It's called on every member,
+// for the local member as well, and with nil labels once a member leaves.
+type Labeler interface {
+	SetLabels(name string, labels map[string]string)
+}
+
+// Role is the part a member plays in the raft cluster. It's advertised by
+// the member's roleTag.
+type Role string
+
+const (
+	Voter    Role = "voter"
+	Nonvoter Role = "nonvoter"
+
+	roleTag = "role"
+	// labelTagPrefix prefixes the tags of the labels of a member
+	labelTagPrefix = "label:"
+)
+
 type Config struct {
 	NodeName       string
 	BindAddr       string
 	Tags           map[string]string
 	StartJoinAddrs []string
+	// Role defaults to Voter
+	Role Role
+	// Labels describe the topology of the member, e.g. its zone and rack.
+	Labels map[string]string
 }
 
 type Membership struct {
@@ -30,6 +58,14 @@ type Membership struct {
 }
 
 func New(handler Handler, config Config) (*Membership, error) {
+	switch config.Role {
+	case "":
+		config.Role = Voter
+	case Voter, Nonvoter:
+	default:
+		return nil, fmt.Errorf("unknown role %q", config.Role)
+	}
+
 	c := &Membership{
 		Config:  config,
 		handler: handler,
@@ -52,7 +88,14 @@ func (m *Membership) setupSerf() error {
 	config := serf.DefaultConfig()
 	config.Init()
 
-	config.Tags = m.Tags
+	config.Tags = make(map[string]string, len(m.Tags)+len(m.Labels)+1)
+	for k, v := range m.Tags {
+		config.Tags[k] = v
+	}
+	for k, v := range m.Labels {
+		config.Tags[labelTagPrefix+k] = v
+	}
+	config.Tags[roleTag] = string(m.Role)
 	config.NodeName = m.Config.NodeName
 
 	config.MemberlistConfig.BindAddr = addr.IP.String()
@@ -82,16 +125,22 @@ func (m *Membership) eventHandler() {
 		switch e.EventType() {
 		case serf.EventMemberJoin:
 			for _, member := range e.(serf.MemberEvent).Members {
+				m.setLabels(member.Name, labels(member))
 				if m.isLocal(member) {
 					continue
 				}
 				m.handleJoin(member)
 			}
+		case serf.EventMemberUpdate:
+			for _, member := range e.(serf.MemberEvent).Members {
+				m.setLabels(member.Name, labels(member))
+			}
 		case serf.EventMemberLeave, serf.EventMemberFailed:
 			for _, member := range e.(serf.MemberEvent).Members {
 				if m.isLocal(member) {
 					return
 				}
+				m.setLabels(member.Name, nil)
 				m.handleLeave(member)
 			}
 		}
@@ -99,7 +148,9 @@ func (m *Membership) eventHandler() {
 }
 
 func (m *Membership) handleJoin(member serf.Member) {
-	err := m.handler.Join(member.Name, member.Tags["rpc_addr"])
+	// members without the tag predate roles and are voters
+	voter := Role(member.Tags[roleTag]) != Nonvoter
+	err := m.handler.Join(member.Name, member.Tags["rpc_addr"], voter)
 	if err != nil {
 		m.logError(err, "failed to join", member)
 	}
@@ -112,6 +163,23 @@ func (m *Membership) handleLeave(member serf.Member) {
 	}
 }
 
+func (m *Membership) setLabels(name string, labels map[string]string) {
+	if labeler, ok := m.handler.(Labeler); ok {
+		labeler.SetLabels(name, labels)
+	}
+}
+
+// labels returns the labels of member from its tags.
+func labels(member serf.Member) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range member.Tags {
+		if label, ok := strings.CutPrefix(k, labelTagPrefix); ok {
+			labels[label] = v
+		}
+	}
+	return labels
+}
+
 func (m *Membership) isLocal(member serf.Member) bool {
 	localMember := m.serf.LocalMember().Name
 	return localMember == member.Name
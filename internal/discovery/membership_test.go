@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,9 +13,9 @@ import (
 
 func TestMembership(t *testing.T) {
 	// act
-	m, handler := setupMember(t, nil)
-	m, _ = setupMember(t, m)
-	m, _ = setupMember(t, m)
+	m, handler := setupMember(t, nil, "")
+	m, _ = setupMember(t, m, "")
+	m, _ = setupMember(t, m, "")
 
 	// assert
 	require.Eventually(t,
@@ -40,7 +41,40 @@ func TestMembership(t *testing.T) {
 	require.Equal(t, fmt.Sprintf("%d", 2), <-handler.leaves)
 }
 
-func setupMember(t *testing.T, members []*Membership) ([]*Membership, *handler) {
+func TestMembershipRole(t *testing.T) {
+	// act
+	m, handler := setupMember(t, nil, Voter)
+	m, _ = setupMember(t, m, Nonvoter)
+
+	// assert
+	join := <-handler.joins
+	require.Equal(t, "1", join["id"])
+	require.Equal(t, "false", join["voter"])
+	require.Equal(t, string(Nonvoter), m[1].serf.LocalMember().Tags[roleTag])
+}
+
+func TestMembershipLabels(t *testing.T) {
+	// act
+	m, handler := setupMember(t, nil, "")
+	m, _ = setupMember(t, m, "", "zone", "eu-west-1b")
+
+	// assert
+	require.Eventually(t, func() bool {
+		return handler.labelsOf("1")["zone"] == "eu-west-1b"
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		labels := handler.labelsOf("0")
+		return labels != nil && len(labels) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, m[1].Leave())
+	require.Eventually(t, func() bool {
+		return handler.labelsOf("1") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+// setupMember adds a member with role and the labels given as key value pairs.
+func setupMember(t *testing.T, members []*Membership, role Role, labels ...string) ([]*Membership, *handler) {
 	id := len(members)
 	port := internal.FreePort(t)
 	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
@@ -52,6 +86,11 @@ func setupMember(t *testing.T, members []*Membership) ([]*Membership, *handler)
 		NodeName: fmt.Sprintf("%d", id),
 		BindAddr: addr,
 		Tags:     tags,
+		Role:     role,
+		Labels:   make(map[string]string),
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		config.Labels[labels[i]] = labels[i+1]
 	}
 
 	handler := &handler{}
@@ -73,13 +112,17 @@ func setupMember(t *testing.T, members []*Membership) ([]*Membership, *handler)
 type handler struct {
 	joins  chan map[string]string
 	leaves chan string
+
+	mu     sync.Mutex
+	labels map[string]map[string]string
 }
 
-func (h *handler) Join(id, addr string) error {
+func (h *handler) Join(id, addr string, voter bool) error {
 	if h.joins != nil {
 		h.joins <- map[string]string{
-			"id":   id,
-			"addr": addr,
+			"id":    id,
+			"addr":  addr,
+			"voter": fmt.Sprint(voter),
 		}
 	}
 	return nil
@@ -91,3 +134,18 @@ func (h *handler) Leave(id string) error {
 	}
 	return nil
 }
+
+func (h *handler) SetLabels(id string, labels map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.labels == nil {
+		h.labels = make(map[string]map[string]string)
+	}
+	h.labels[id] = labels
+}
+
+func (h *handler) labelsOf(id string) map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.labels[id]
+}
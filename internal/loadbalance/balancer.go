@@ -0,0 +1,116 @@
+package loadbalance
+
+import (
+	"encoding/json"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Route names the servers the calls of a method are sent to.
+type Route string
+
+const (
+	// RouteLeader sends calls to the leader.
+	RouteLeader Route = "leader"
+	// RouteFollower spreads calls across the followers, preferring
+	// non-voters, and falls back to the leader if none are available.
+	RouteFollower Route = "follower"
+)
+
+// Config is the load balancing config of the proglog balancer. The resolver
+// puts it into the service config of the client connections it resolves.
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	// Routes maps full method names to the servers their calls are sent
+	// to. Calls of other methods go to the leader.
+	Routes map[string]Route `json:"routes,omitempty"`
+	// MaxLagEntries excludes followers which are further behind the
+	// committed entries of the leader from the followers calls are sent to.
+	// Zero keeps all.
+	MaxLagEntries uint64 `json:"maxLagEntries,omitempty"`
+	// MaxLagMs excludes followers which were out of contact with the leader
+	// for longer from the followers calls are sent to. Zero keeps all.
+	MaxLagMs uint64 `json:"maxLagMs,omitempty"`
+	// Zone is the zone of the client. Calls routed to followers go to the
+	// followers labeled with the same ZoneLabel, unless none are ready.
+	Zone string `json:"zone,omitempty"`
+}
+
+// DefaultConfig sends reads to the followers and all other calls to the leader.
+var DefaultConfig = Config{
+	Routes: map[string]Route{
+		api.Log_Get_FullMethodName:              RouteFollower,
+		api.Log_GetStream_FullMethodName:        RouteFollower,
+		api.Log_ReadRange_FullMethodName:        RouteFollower,
+		api.Log_GetOffsetForTime_FullMethodName: RouteFollower,
+	},
+	MaxLagEntries: 1000,
+	MaxLagMs:      5000,
+}
+
+// ZoneLabel is the label of the servers holding their zone.
+const ZoneLabel = "zone"
+
+// lagKey, lagEntriesKey and zoneKey are the balancer attributes of resolved
+// addresses holding the lags and the zone of the server. Unlike attributes,
+// balancer attributes can change without replacing the connection to the
+// server.
+const (
+	lagKey        = "lag_ms"
+	lagEntriesKey = "lag_entries"
+	zoneKey       = "zone"
+)
+
+var _ balancer.ConfigParser = builder{}
+
+type builder struct{}
+
+// Build implements balancer.Builder.
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	picker := &Picker{}
+	return &routingBalancer{
+		Balancer: base.NewBalancerBuilder(Name, picker, base.Config{}).Build(cc, opts),
+		picker:   picker,
+	}
+}
+
+// Name implements balancer.Builder.
+func (builder) Name() string {
+	return Name
+}
+
+// ParseConfig implements balancer.ConfigParser.
+func (builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	config := &Config{}
+	if err := json.Unmarshal(js, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// routingBalancer hands the config and the lags and zones of the servers to
+// the picker, which the base balancer doesn't pass on.
+type routingBalancer struct {
+	balancer.Balancer
+	picker *Picker
+}
+
+func (b *routingBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	config, _ := s.BalancerConfig.(*Config)
+	servers := make(map[string]serverInfo)
+	for _, addr := range s.ResolverState.Addresses {
+		lag, _ := addr.BalancerAttributes.Value(lagKey).(uint64)
+		lagEntries, _ := addr.BalancerAttributes.Value(lagEntriesKey).(uint64)
+		zone, _ := addr.BalancerAttributes.Value(zoneKey).(string)
+		servers[addr.Addr] = serverInfo{lag: lag, lagEntries: lagEntries, zone: zone}
+	}
+	b.picker.update(config, servers)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func init() {
+	balancer.Register(builder{})
+}
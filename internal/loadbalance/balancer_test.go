@@ -0,0 +1,128 @@
+package loadbalance
+
+import (
+	"testing"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := builder{}.ParseConfig([]byte(`{
+		"routes": {"/log.v1.Log/GetOffsets": "follower"},
+		"maxLagMs": 100,
+		"maxLagEntries": 50
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, &Config{
+		Routes:        map[string]Route{api.Log_GetOffsets_FullMethodName: RouteFollower},
+		MaxLagMs:      100,
+		MaxLagEntries: 50,
+	}, config)
+
+	_, err = builder{}.ParseConfig([]byte(`{"routes": []}`))
+	require.Error(t, err)
+}
+
+func TestPickerRoutesByConfig(t *testing.T) {
+	// arrange
+	picker := &Picker{}
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	var subConns []balancer.SubConn
+	for i, addr := range []string{"leader:9001", "lagging:9002", "follower:9003"} {
+		sc := &struct{ balancer.SubConn }{}
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New("is_leader", i == 0),
+		}}
+		subConns = append(subConns, sc)
+	}
+	picker.Build(buildInfo)
+
+	// act
+	picker.update(&Config{
+		Routes:   map[string]Route{api.Log_GetOffsets_FullMethodName: RouteFollower},
+		MaxLagMs: 100,
+	}, map[string]serverInfo{"lagging:9002": {lag: 500}, "follower:9003": {lag: 50}})
+
+	// assert
+	for i := 0; i < 4; i++ {
+		gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: api.Log_GetOffsets_FullMethodName})
+		require.NoError(t, err)
+		require.Same(t, subConns[2], gotPick.SubConn)
+	}
+	// methods without a route go to the leader
+	gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: api.Log_Get_FullMethodName})
+	require.NoError(t, err)
+	require.Same(t, subConns[0], gotPick.SubConn)
+
+	// the leader takes over if all followers lag
+	picker.update(&Config{
+		Routes:   map[string]Route{api.Log_GetOffsets_FullMethodName: RouteFollower},
+		MaxLagMs: 10,
+	}, map[string]serverInfo{"lagging:9002": {lag: 500}, "follower:9003": {lag: 50}})
+	gotPick, err = picker.Pick(balancer.PickInfo{FullMethodName: api.Log_GetOffsets_FullMethodName})
+	require.NoError(t, err)
+	require.Same(t, subConns[0], gotPick.SubConn)
+
+	// followers behind the committed entries are excluded as well
+	picker.update(&Config{
+		Routes:        map[string]Route{api.Log_GetOffsets_FullMethodName: RouteFollower},
+		MaxLagEntries: 100,
+	}, map[string]serverInfo{"lagging:9002": {lagEntries: 500}, "follower:9003": {lagEntries: 50}})
+	for i := 0; i < 4; i++ {
+		gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: api.Log_GetOffsets_FullMethodName})
+		require.NoError(t, err)
+		require.Same(t, subConns[2], gotPick.SubConn)
+	}
+}
+
+func TestPickerPrefersFollowersInZone(t *testing.T) {
+	// arrange
+	picker := &Picker{}
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	var subConns []balancer.SubConn
+	for i, addr := range []string{"leader:9001", "near:9002", "far:9003", "far-replica:9004"} {
+		sc := &struct{ balancer.SubConn }{}
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New("is_leader", i == 0).WithValue("is_voter", i != 3),
+		}}
+		subConns = append(subConns, sc)
+	}
+	picker.Build(buildInfo)
+	servers := map[string]serverInfo{
+		"leader:9001":      {zone: "a"},
+		"near:9002":        {zone: "a"},
+		"far:9003":         {zone: "b"},
+		"far-replica:9004": {zone: "b"},
+	}
+	config := DefaultConfig
+	config.Zone = "a"
+
+	// act
+	picker.update(&config, servers)
+
+	// assert
+	info := balancer.PickInfo{FullMethodName: api.Log_Get_FullMethodName}
+	for i := 0; i < 4; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Same(t, subConns[1], gotPick.SubConn)
+	}
+
+	// other zones take over once the zone has no ready followers
+	delete(buildInfo.ReadySCs, subConns[1])
+	picker.Build(buildInfo)
+	gotPick, err := picker.Pick(info)
+	require.NoError(t, err)
+	require.Same(t, subConns[3], gotPick.SubConn)
+}
@@ -1,65 +1,189 @@
 package loadbalance
 
 import (
-	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
 
+	api "github.com/justagabriel/proglog/api/v1"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 )
 
+// ewmaWeight is the weight of the latest latency in the moving average.
+const ewmaWeight = 0.3
+
+// streams measure their lifetime instead of a latency, so they only count as
+// outstanding requests.
+var streams = map[string]bool{
+	api.Log_CreateStream_FullMethodName: true,
+	api.Log_GetStream_FullMethodName:    true,
+	api.Log_WatchServers_FullMethodName: true,
+}
+
 var _ base.PickerBuilder = (*Picker)(nil)
 
+// Picker routes calls by the routes of its Config. Calls routed to followers
+// go to the one with the lowest latency weighted by its outstanding requests.
 type Picker struct {
 	mu        sync.Mutex
+	config    *Config
 	leader    balancer.SubConn
 	followers []balancer.SubConn
-	current   uint64
+	// nonvoters are preferred for reads, as they don't slow down commits
+	nonvoters []balancer.SubConn
+	addrs     map[balancer.SubConn]string
+	servers   map[string]serverInfo
+	stats     map[balancer.SubConn]*connStats
+	current   int
+}
+
+// serverInfo is what the resolver tells about a server besides its role.
+type serverInfo struct {
+	lag        uint64
+	lagEntries uint64
+	zone       string
+}
+
+type connStats struct {
+	// latency is the moving average of the latencies in nanoseconds
+	latency     float64
+	outstanding int
 }
 
 func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	var followers []balancer.SubConn
+	var followers, nonvoters []balancer.SubConn
+	addrs := make(map[balancer.SubConn]string)
+	stats := make(map[balancer.SubConn]*connStats)
+	p.leader = nil
 	for sc, scInfo := range buildInfo.ReadySCs {
+		addrs[sc] = scInfo.Address.Addr
 		isLeader := scInfo.Address.Attributes.Value("is_leader").(bool)
 		if isLeader {
 			p.leader = sc
 			continue
 		}
+		// keep the stats of followers which stay ready
+		stats[sc] = p.stats[sc]
+		if stats[sc] == nil {
+			stats[sc] = &connStats{}
+		}
+		// servers of resolvers without suffrages are voters
+		if isVoter, ok := scInfo.Address.Attributes.Value("is_voter").(bool); ok && !isVoter {
+			nonvoters = append(nonvoters, sc)
+			continue
+		}
 		followers = append(followers, sc)
 	}
 	p.followers = followers
+	p.nonvoters = nonvoters
+	p.addrs = addrs
+	p.stats = stats
 	return p
 }
 
+// update sets the config and the infos of the servers by address.
+func (p *Picker) update(config *Config, servers map[string]serverInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+	p.servers = servers
+}
+
 var _ balancer.Picker = (*Picker)(nil)
 
 func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	config := p.config
+	if config == nil {
+		config = &DefaultConfig
+	}
+
 	var result balancer.PickResult
-	if strings.Contains(info.FullMethodName, "Create") || len(p.followers) == 0 {
+	if config.Routes[info.FullMethodName] == RouteFollower {
+		result.SubConn = p.nextFollower(config)
+	}
+	if result.SubConn == nil {
 		result.SubConn = p.leader
-	} else if strings.Contains(info.FullMethodName, "Get") {
-		result.SubConn = p.nextFollower()
 	}
 	if result.SubConn == nil {
 		return result, balancer.ErrNoSubConnAvailable
 	}
+
+	if stats, ok := p.stats[result.SubConn]; ok {
+		stats.outstanding++
+		start := time.Now()
+		isStream := streams[info.FullMethodName]
+		result.Done = func(balancer.DoneInfo) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			stats.outstanding--
+			if !isStream {
+				stats.latency += ewmaWeight * (float64(time.Since(start)) - stats.latency)
+			}
+		}
+	}
 	return result, nil
 }
 
-func (p *Picker) nextFollower() balancer.SubConn {
-	cur := atomic.AddUint64(&p.current, uint64(1))
-	len := uint64(len(p.followers))
-	idx := int(cur % len)
-	return p.followers[idx]
+// nextFollower returns the follower with the lowest latency weighted by its
+// outstanding requests, ignoring followers which lag more than the config
+// allows. Followers in the zone of the client are preferred over the others,
+// non-voters over voters. Idle followers without latencies are tried first.
+// Ties are broken round-robin.
+func (p *Picker) nextFollower(config *Config) balancer.SubConn {
+	var candidates []balancer.SubConn
+	for _, sameZone := range []bool{true, false} {
+		if sameZone && config.Zone == "" {
+			continue
+		}
+		for _, followers := range [][]balancer.SubConn{p.nonvoters, p.followers} {
+			candidates = p.eligible(followers, config, sameZone)
+			if len(candidates) > 0 {
+				break
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	p.current++
+	var best balancer.SubConn
+	var bestScore float64
+	for i := range candidates {
+		sc := candidates[(p.current+i)%len(candidates)]
+		stats := p.stats[sc]
+		// the nanosecond keeps outstanding requests apart without latencies
+		score := (stats.latency + 1) * float64(stats.outstanding+1)
+		if best == nil || score < bestScore {
+			best, bestScore = sc, score
+		}
+	}
+	return best
 }
 
-func init() {
-	balancer.Register(
-		base.NewBalancerBuilder(Name, &Picker{}, base.Config{}),
-	)
+// eligible returns the followers which are caught up and, if sameZone is set,
+// in the zone of the client.
+func (p *Picker) eligible(followers []balancer.SubConn, config *Config, sameZone bool) []balancer.SubConn {
+	var eligible []balancer.SubConn
+	for _, sc := range followers {
+		server := p.servers[p.addrs[sc]]
+		if config.MaxLagMs > 0 && server.lag > config.MaxLagMs {
+			continue
+		}
+		if config.MaxLagEntries > 0 && server.lagEntries > config.MaxLagEntries {
+			continue
+		}
+		if sameZone && server.zone != config.Zone {
+			continue
+		}
+		eligible = append(eligible, sc)
+	}
+	return eligible
 }
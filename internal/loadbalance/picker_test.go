@@ -2,7 +2,9 @@ package loadbalance_test
 
 import (
 	"testing"
+	"time"
 
+	api "github.com/justagabriel/proglog/api/v1"
 	"github.com/justagabriel/proglog/internal/loadbalance"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/attributes"
@@ -14,8 +16,8 @@ import (
 func TestPickerNoSubConnAvailable(t *testing.T) {
 	picker := &loadbalance.Picker{}
 	for _, method := range []string{
-		"/log.vX.Log/Create",
-		"/log.vC.Log/Get",
+		api.Log_Create_FullMethodName,
+		api.Log_Get_FullMethodName,
 	} {
 		info := balancer.PickInfo{
 			FullMethodName: method,
@@ -63,7 +65,7 @@ func setupTest() (*loadbalance.Picker, []*subConn) {
 func TestPickerCreatesToLeader(t *testing.T) {
 	picker, subConns := setupTest()
 	info := balancer.PickInfo{
-		FullMethodName: "/log.vX.Log/Create",
+		FullMethodName: api.Log_Create_FullMethodName,
 	}
 	for i := 0; i < 5; i++ {
 		gotPick, err := picker.Pick(info)
@@ -71,3 +73,79 @@ func TestPickerCreatesToLeader(t *testing.T) {
 		require.Equal(t, subConns[0], gotPick.SubConn)
 	}
 }
+
+func TestPickerGetsFromFollowers(t *testing.T) {
+	picker, subConns := setupTest()
+	info := balancer.PickInfo{
+		FullMethodName: api.Log_Get_FullMethodName,
+	}
+	picked := make(map[balancer.SubConn]int)
+	for i := 0; i < 4; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		picked[gotPick.SubConn]++
+	}
+	// round-robin across the followers, the leader only takes writes
+	require.Equal(t, map[balancer.SubConn]int{subConns[1]: 2, subConns[2]: 2}, picked)
+}
+
+func TestPickerRoutesUnlistedMethodsToLeader(t *testing.T) {
+	picker, subConns := setupTest()
+	for _, method := range []string{
+		api.Log_GetServers_FullMethodName,
+		api.Log_GetOffsets_FullMethodName,
+		api.Admin_GetRaftState_FullMethodName,
+	} {
+		gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.NoError(t, err)
+		require.Same(t, subConns[0], gotPick.SubConn, method)
+	}
+}
+
+func TestPickerPrefersFastFollowers(t *testing.T) {
+	picker, subConns := setupTest()
+	info := balancer.PickInfo{
+		FullMethodName: api.Log_Get_FullMethodName,
+	}
+
+	// the first calls measure a latency for both followers
+	slow, err := picker.Pick(info)
+	require.NoError(t, err)
+	fast, err := picker.Pick(info)
+	require.NoError(t, err)
+	require.NotSame(t, slow.SubConn, fast.SubConn)
+	fast.Done(balancer.DoneInfo{})
+	time.Sleep(20 * time.Millisecond)
+	slow.Done(balancer.DoneInfo{})
+
+	for i := 0; i < 3; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Same(t, fast.SubConn, gotPick.SubConn)
+		require.NotSame(t, subConns[0], gotPick.SubConn)
+		gotPick.Done(balancer.DoneInfo{})
+	}
+}
+
+func TestPickerPrefersNonvotersForGets(t *testing.T) {
+	picker, subConns := setupTest()
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for i, sc := range subConns {
+		addr := resolver.Address{
+			Attributes: attributes.New("is_leader", i == 0).WithValue("is_voter", i != 2),
+		}
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+	}
+	picker.Build(buildInfo)
+
+	info := balancer.PickInfo{
+		FullMethodName: api.Log_Get_FullMethodName,
+	}
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[2], gotPick.SubConn)
+	}
+}
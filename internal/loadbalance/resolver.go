@@ -2,6 +2,7 @@ package loadbalance
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -18,68 +19,128 @@ const (
 	Name string = "proglog"
 )
 
+// The resolver reconnects to WatchServers with an exponential backoff between
+// these bounds.
+const (
+	minWatchBackoff = 100 * time.Millisecond
+	maxWatchBackoff = 5 * time.Second
+)
+
+// Resolver resolves the servers of the cluster by watching them at the target
+// server, so clients follow leader changes as soon as raft reports them.
 type Resolver struct {
+	// Config is the load balancing config of the connections built by
+	// this Resolver. It defaults to DefaultConfig.
+	Config *Config
+
 	mu            sync.Mutex
 	clientConn    resolver.ClientConn
 	resolverConn  *grpc.ClientConn
 	serviceConfig *serviceconfig.ParseResult
 	logger        *zap.Logger
+
+	// resolveNow cuts the backoff of a failed watch short
+	resolveNow chan struct{}
+	cancel     context.CancelFunc
+	watching   sync.WaitGroup
 }
 
 // Close implements resolver.Resolver.
 func (r *Resolver) Close() {
+	r.cancel()
+	r.watching.Wait()
 	if err := r.resolverConn.Close(); err != nil {
 		r.logger.Error("failed to close conn", zap.Error(err))
 	}
 }
 
-// ResolveNow implements resolver.Resolver.
+// ResolveNow implements resolver.Resolver. gRPC calls it once connections
+// fail, so the servers are fetched right away instead of waiting for the
+// watch to recover.
 func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+	r.resolve()
+}
+
+func (r *Resolver) resolve() {
 	client := api.NewLogClient(r.resolverConn)
-	ctx := context.Background()
+	resp, err := client.GetServers(context.Background(), &api.GetServersRequest{})
+	if err != nil {
+		r.logger.Error("failed to resolve server", zap.Error(err))
+		return
+	}
+	r.update(resp.Servers)
+}
 
-	retries := 3
-	for i := 0; i < retries; i++ {
-		resp, err := client.GetServers(ctx, &api.GetServersRequest{})
-		if err != nil {
-			r.logger.Error("failed to resolve server", zap.Error(err))
-			return
-		}
+// watch streams the changes of the servers until ctx is done.
+func (r *Resolver) watch(ctx context.Context) {
+	defer r.watching.Done()
+	client := api.NewLogClient(r.resolverConn)
 
-		var foundLeader bool
-		var addrs []resolver.Address
-		for _, server := range resp.Servers {
-			if server.IsLeader {
-				foundLeader = true
+	backoff := minWatchBackoff
+	for {
+		stream, err := client.WatchServers(ctx, &api.WatchServersRequest{})
+		for err == nil {
+			var resp *api.GetServersResponse
+			resp, err = stream.Recv()
+			if err == nil {
+				r.update(resp.Servers)
+				backoff = minWatchBackoff
 			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		r.logger.Warn("failed to watch servers", zap.Error(err), zap.Duration("backoff", backoff))
 
-			addr := resolver.Address{
-				Addr:       server.RpcAddr,
-				Attributes: attributes.New("is_leader", server.IsLeader),
-			}
-			addrs = append(addrs, addr)
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.resolveNow:
+		case <-time.After(backoff):
 		}
+		backoff = min(2*backoff, maxWatchBackoff)
+	}
+}
 
-		if !foundLeader {
-			isLastRetry := (i == (retries - 1))
-			if isLastRetry {
-				r.logger.Error("no leader found, no more retries")
-			} else {
-				r.logger.Warn("no leader found")
-			}
-			time.Sleep(time.Duration(i*300) * time.Millisecond)
-			continue
+// update hands the servers to gRPC. Servers without a leader are in the middle
+// of an election, so the previous servers are kept until the next change.
+func (r *Resolver) update(servers []*api.Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var foundLeader bool
+	var addrs []resolver.Address
+	for _, server := range servers {
+		if server.IsLeader {
+			foundLeader = true
 		}
 
-		resolverState := resolver.State{
-			Addresses:     addrs,
-			ServiceConfig: r.serviceConfig,
+		isVoter := server.Suffrage != api.Suffrage_SUFFRAGE_NONVOTER
+		addr := resolver.Address{
+			Addr: server.RpcAddr,
+			Attributes: attributes.New("is_leader", server.IsLeader).
+				WithValue("is_voter", isVoter),
+			BalancerAttributes: attributes.New(lagKey, server.LagMs).
+				WithValue(lagEntriesKey, server.LagEntries).
+				WithValue(zoneKey, server.Labels[ZoneLabel]),
 		}
-		r.clientConn.UpdateState(resolverState)
-		break
+		addrs = append(addrs, addr)
+	}
+
+	if !foundLeader {
+		r.logger.Warn("no leader found")
+		return
 	}
+
+	resolverState := resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	}
+	r.clientConn.UpdateState(resolverState)
 }
 
 // Scheme implements resolver.Builder.
@@ -87,22 +148,40 @@ func (*Resolver) Scheme() string {
 	return Name
 }
 
-// Build implements resolver.Builder.
-func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	r.logger = zap.L().Named("resolver")
-	r.clientConn = cc
+// Build implements resolver.Builder. Each client connection gets its own
+// Resolver watching the target.
+func (b *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	config := b.Config
+	if config == nil {
+		config = &DefaultConfig
+	}
+	lbConfig, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resolver{
+		Config:     config,
+		clientConn: cc,
+		logger:     zap.L().Named("resolver"),
+		resolveNow: make(chan struct{}, 1),
+	}
 	var dialOpts []grpc.DialOption
 	if opts.DialCreds != nil {
 		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
 	}
-	configStr := fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name)
+	configStr := fmt.Sprintf(`{"loadBalancingConfig":[{"%s":%s}]}`, Name, lbConfig)
 	r.serviceConfig = r.clientConn.ParseServiceConfig(configStr)
-	var err error
 	r.resolverConn, err = grpc.Dial(target.Endpoint(), dialOpts...)
 	if err != nil {
 		return nil, err
 	}
-	r.ResolveNow(resolver.ResolveNowOptions{})
+	r.resolve()
+
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	r.watching.Add(1)
+	go r.watch(ctx)
 	return r, nil
 }
 
@@ -3,7 +3,9 @@ package loadbalance
 import (
 	"net"
 	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	api "github.com/justagabriel/proglog/api/v1"
 	"github.com/justagabriel/proglog/internal/config"
@@ -12,6 +14,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
 )
@@ -51,28 +54,102 @@ func TestResolver(t *testing.T) {
 	opts := resolver.BuildOptions{
 		DialCreds: clientCreds,
 	}
-	r := &Resolver{}
 	url, err := url.Parse("dns:" + ln.Addr().String())
 	require.NoError(t, err)
-	_, err = r.Build(resolver.Target{
+	r, err := (&Resolver{}).Build(resolver.Target{
 		URL: *url,
 	}, conn, opts)
 	require.NoError(t, err)
+	defer r.Close()
 	wantState := resolver.State{
 		Addresses: []resolver.Address{
-			{Addr: "localhost:9001",
-				Attributes: attributes.New("is_leader", true),
+			{
+				Addr:               "localhost:9001",
+				Attributes:         attributes.New("is_leader", true).WithValue("is_voter", true),
+				BalancerAttributes: attributes.New(lagKey, uint64(0)).WithValue(lagEntriesKey, uint64(0)).WithValue(zoneKey, ""),
 			}, {
-				Addr:       "localhost:9002",
-				Attributes: attributes.New("is_leader", false),
+				Addr:               "localhost:9002",
+				Attributes:         attributes.New("is_leader", false).WithValue("is_voter", true),
+				BalancerAttributes: attributes.New(lagKey, uint64(20)).WithValue(lagEntriesKey, uint64(3)).WithValue(zoneKey, "eu-west-1a"),
+			}, {
+				Addr:               "localhost:9003",
+				Attributes:         attributes.New("is_leader", false).WithValue("is_voter", false),
+				BalancerAttributes: attributes.New(lagKey, uint64(0)).WithValue(lagEntriesKey, uint64(0)).WithValue(zoneKey, ""),
 			},
 		},
 	}
-	require.Equal(t, wantState, conn.state)
+	require.Equal(t, wantState, conn.State())
 
-	conn.state.Addresses = nil
+	conn.UpdateState(resolver.State{})
 	r.ResolveNow(resolver.ResolveNowOptions{})
-	require.Equal(t, wantState, conn.state)
+	require.Equal(t, wantState, conn.State())
+}
+
+func TestResolverWatchesServers(t *testing.T) {
+	// arrange
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	servers := &watchServers{changed: make(chan struct{}), leader: "localhost:9001"}
+	srv, err := server.NewGRPCServer(&server.Config{
+		GetServerer: servers,
+	})
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn := &clienConn{}
+	url, err := url.Parse("dns:" + ln.Addr().String())
+	require.NoError(t, err)
+	r, err := (&Resolver{}).Build(resolver.Target{
+		URL: *url,
+	}, conn, resolver.BuildOptions{DialCreds: insecure.NewCredentials()})
+	require.NoError(t, err)
+	defer r.Close()
+
+	// act
+	servers.elect("localhost:9002")
+
+	// assert
+	require.Eventually(t, func() bool {
+		addrs := conn.State().Addresses
+		return len(addrs) == 2 && addrs[1].Attributes.Value("is_leader") == true
+	}, time.Second, 10*time.Millisecond)
+
+	// elections in progress don't reach the client
+	servers.elect("")
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, true, conn.State().Addresses[1].Attributes.Value("is_leader"))
+}
+
+// watchServers is a cluster of two servers whose leader changes.
+type watchServers struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	leader  string
+}
+
+func (s *watchServers) GetServers() ([]*api.Server, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []*api.Server{
+		{Id: "first", RpcAddr: "localhost:9001", IsLeader: s.leader == "localhost:9001"},
+		{Id: "second", RpcAddr: "localhost:9002", IsLeader: s.leader == "localhost:9002"},
+	}, nil
+}
+
+func (s *watchServers) ServersChanged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+func (s *watchServers) elect(leader string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader = leader
+	close(s.changed)
+	s.changed = make(chan struct{})
 }
 
 type getServers struct{}
@@ -85,23 +162,41 @@ func (s *getServers) GetServers() ([]*api.Server, error) {
 			IsLeader: true,
 		},
 		{
-			Id:       "follower",
-			RpcAddr:  "localhost:9002",
+			Id:         "follower",
+			RpcAddr:    "localhost:9002",
+			IsLeader:   false,
+			LagMs:      20,
+			LagEntries: 3,
+			Labels:     map[string]string{ZoneLabel: "eu-west-1a"},
+		},
+		{
+			Id:       "replica",
+			RpcAddr:  "localhost:9003",
 			IsLeader: false,
+			Suffrage: api.Suffrage_SUFFRAGE_NONVOTER,
 		},
 	}, nil
 }
 
 type clienConn struct {
 	resolver.ClientConn
+	mu    sync.Mutex
 	state resolver.State
 }
 
 func (c *clienConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.state = state
 	return nil
 }
 
+func (c *clienConn) State() resolver.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
 func (c *clienConn) ReportError(err error) {
 }
 
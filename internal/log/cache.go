@@ -0,0 +1,105 @@
+package log
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// segmentCache bounds the amount of sealed segments with open files. Once
+// more than max are open, the least recently used ones are closed unless they
+// are still in use.
+type segmentCache struct {
+	mu  sync.Mutex
+	max int
+	lru *list.List
+}
+
+func newSegmentCache(max int) *segmentCache {
+	return &segmentCache{
+		max: max,
+		lru: list.New(),
+	}
+}
+
+// errSegmentRetired is returned for segments which were removed from the log
+// since they were looked up, e.g. by a compaction.
+var errSegmentRetired = errors.New("segment retired")
+
+// acquire opens the files of s if needed and keeps them open until release.
+func (c *segmentCache) acquire(s *segment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.retired {
+		return errSegmentRetired
+	}
+	if s.elem != nil {
+		c.lru.MoveToBack(s.elem)
+	} else {
+		if err := s.open(); err != nil {
+			return err
+		}
+		s.elem = c.lru.PushBack(s)
+	}
+	s.refs++
+
+	for e := c.lru.Front(); e != nil && c.lru.Len() > c.max; {
+		next := e.Next()
+		evicted := e.Value.(*segment)
+		if evicted.refs == 0 {
+			c.lru.Remove(e)
+			evicted.elem = nil
+			if err := evicted.Close(); err != nil {
+				zap.L().Named("log").Error("failed to close segment", zap.Error(err), zap.String("store", evicted.storePath))
+			}
+		}
+		e = next
+	}
+	return nil
+}
+
+func (c *segmentCache) release(s *segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.refs--
+	if s.refs == 0 && s.closer != nil {
+		if err := s.closer(); err != nil {
+			zap.L().Named("log").Error("failed to close segment", zap.Error(err), zap.String("store", s.storePath))
+		}
+		s.closer = nil
+	}
+}
+
+// retire forgets s once the log no longer has it and closes it with closer,
+// either right away or, while s is in use, on its last release.
+func (c *segmentCache) retire(s *segment, closer func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.elem != nil {
+		c.lru.Remove(s.elem)
+		s.elem = nil
+	}
+	s.retired = true
+	if s.refs > 0 {
+		s.closer = closer
+		return nil
+	}
+	return closer()
+}
+
+// isOpen reports whether the files of s are open.
+func (c *segmentCache) isOpen(s *segment) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return s.elem != nil
+}
+
+// open returns the amount of segments with open files.
+func (c *segmentCache) open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,283 @@
+package log
+
+import (
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+// Compaction rewrites a segment into <base>.store.cleaned, <base>.index.cleaned
+// and <base>.timeindex.cleaned first. Once all files are complete they are
+// renamed to .swap, which marks the compaction as done, and then replace the
+// files of the segment. finishCompaction relies on this order after a crash.
+const (
+	cleanedExt = ".cleaned"
+	swapExt    = ".swap"
+)
+
+// Compact rewrites the sealed segments so that only the newest record of
+// every key is kept. Records without a key are never removed and all kept
+// records retain their offsets, which leaves gaps in the compacted segments.
+// Tombstones expire by the age of their segment.
+func (l *Log) Compact() error {
+	return l.compact(time.Now())
+}
+
+// compactEvery calls compact every interval until done is closed. Replicated
+// logs compact through raft instead of compacting their replica right away.
+func (l *Log) compactEvery(interval time.Duration, compact func(now time.Time) error, done <-chan struct{}) {
+	logger := zap.L().Named("log")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := compact(now); err != nil {
+				logger.Error("failed to compact log", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (l *Log) compact(now time.Time) error {
+	retention := l.Config.Compaction.TombstoneRetention
+	return l.compactBelow(math.MaxUint64, func(s *segment, _ *api.Record) bool {
+		return now.Sub(s.modTime) > retention
+	})
+}
+
+// compactReplica compacts the records below upTo and removes the tombstones
+// stamped before tombstonesBefore. Unlike the age of segments, both are the
+// same on every replica, so the replicas of a log keep the same records.
+func (l *Log) compactReplica(upTo uint64, tombstonesBefore int64) error {
+	return l.compactBelow(upTo, func(_ *segment, record *api.Record) bool {
+		return record.Timestamp < tombstonesBefore
+	})
+}
+
+// compactBelow compacts the sealed segments, considering only the records
+// below upTo. expired reports whether a tombstone of a segment is removed.
+// The segments are read without the lock of the log, which is only taken to
+// swap in their compacted files, so appends continue meanwhile.
+func (l *Log) compactBelow(upTo uint64, expired func(s *segment, record *api.Record) bool) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	segments := slices.Clone(l.segments)
+	l.mu.RUnlock()
+
+	// records appended after this point can only make more records
+	// obsolete, so the compaction never drops the newest record of a key
+	latest := make(map[string]uint64)
+	for _, s := range segments {
+		err := l.scanSegment(s, func(record *api.Record, _ uint64) error {
+			if len(record.Key) > 0 && record.Offset < upTo {
+				latest[string(record.Key)] = record.Offset
+			}
+			return nil
+		})
+		// the records of retired segments were truncated or the log closed
+		if err != nil && err != errSegmentRetired {
+			return err
+		}
+	}
+
+	for _, s := range segments[:len(segments)-1] {
+		keep := func(record *api.Record) bool {
+			if len(record.Key) == 0 || record.Offset >= upTo {
+				return true
+			}
+			if latest[string(record.Key)] != record.Offset {
+				return false
+			}
+			return len(record.Value) > 0 || !expired(s, record)
+		}
+		if err := l.compactSegment(s, keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanSegment calls fn for the records of s. Only the active segment is read
+// under the lock of the log, sealed ones are kept open by the segment cache.
+func (l *Log) scanSegment(s *segment, fn func(record *api.Record, pos uint64) error) error {
+	l.mu.RLock()
+	if s == l.activeSegment && !s.retired {
+		defer l.mu.RUnlock()
+		return s.forEach(fn)
+	}
+	err := l.cache.acquire(s)
+	l.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer l.cache.release(s)
+	return s.forEach(fn)
+}
+
+func (l *Log) compactSegment(s *segment, keep func(record *api.Record) bool) error {
+	cleaned, err := l.cleanSegment(s, keep)
+	if err == errSegmentRetired {
+		return nil
+	}
+	if err != nil || !cleaned {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.swapSegment(s)
+}
+
+// cleanSegment writes the records of s accepted by keep to the .cleaned files
+// of the segment. It reports false without writing anything if all records
+// would be kept.
+func (l *Log) cleanSegment(s *segment, keep func(record *api.Record) bool) (bool, error) {
+	if err := l.cache.acquire(s); err != nil {
+		return false, err
+	}
+	defer l.cache.release(s)
+
+	var drop bool
+	err := s.forEach(func(record *api.Record, _ uint64) error {
+		drop = drop || !keep(record)
+		return nil
+	})
+	if err != nil || !drop {
+		return false, err
+	}
+
+	storePath, indexPath, timeIndexPath := segmentPaths(l.Dir, s.baseOffset, cleanedExt)
+	cleaned, err := openSegment(storePath, indexPath, timeIndexPath, s.baseOffset, l.Config)
+	if err != nil {
+		return false, err
+	}
+	err = s.forEach(func(record *api.Record, _ uint64) error {
+		if !keep(record) {
+			return nil
+		}
+		_, err := cleaned.appendAt(record)
+		return err
+	})
+	if err == nil {
+		err = cleaned.store.Sync()
+	}
+	if closeErr := cleaned.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// retention goes by the age of the records, not of the rewrite
+		err = os.Chtimes(storePath, s.modTime, s.modTime)
+	}
+	if err != nil {
+		removeFiles(storePath, indexPath, timeIndexPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// swapSegment replaces the files of s by its .cleaned files. The caller must
+// hold the write lock of the log.
+func (l *Log) swapSegment(s *segment) error {
+	cleaned := fileNames(segmentPaths(l.Dir, s.baseOffset, cleanedExt))
+
+	idx := -1
+	for i, segment := range l.segments {
+		if segment == s {
+			idx = i
+		}
+	}
+	if idx < 0 || s.retired {
+		// the segment was truncated, or the log closed, while it was
+		// compacted
+		removeFiles(cleaned...)
+		return nil
+	}
+
+	swap := fileNames(segmentPaths(l.Dir, s.baseOffset, swapExt))
+	for i := range cleaned {
+		if err := os.Rename(cleaned[i], swap[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := l.cache.retire(s, s.Close); err != nil {
+		return err
+	}
+	files := fileNames(segmentPaths(l.Dir, s.baseOffset, ""))
+	for i := range swap {
+		if err := os.Rename(swap[i], files[i]); err != nil {
+			return err
+		}
+	}
+
+	compacted, err := loadSegment(l.Dir, s.baseOffset, l.Config)
+	if err != nil {
+		return err
+	}
+	l.segments[idx] = compacted
+	return nil
+}
+
+// finishCompaction completes the compactions which were interrupted after
+// their .swap files were written and discards the others. It returns the
+// remaining files of the log directory.
+func (l *Log) finishCompaction(files []os.DirEntry) ([]os.DirEntry, error) {
+	swapped := make(map[string]bool)
+	var pending []string
+	for _, file := range files {
+		switch path.Ext(file.Name()) {
+		case swapExt:
+			swapped[segmentBase(file.Name())] = true
+			pending = append(pending, file.Name())
+		case cleanedExt:
+			pending = append(pending, file.Name())
+		}
+	}
+	if len(pending) == 0 {
+		return files, nil
+	}
+
+	for _, name := range pending {
+		var err error
+		if swapped[segmentBase(name)] {
+			err = os.Rename(
+				path.Join(l.Dir, name),
+				path.Join(l.Dir, strings.TrimSuffix(name, path.Ext(name))),
+			)
+		} else {
+			err = os.Remove(path.Join(l.Dir, name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return os.ReadDir(l.Dir)
+}
+
+func fileNames(storePath, indexPath, timeIndexPath string) []string {
+	return []string{storePath, indexPath, timeIndexPath}
+}
+
+func removeFiles(names ...string) {
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+// segmentBase returns the base offset part of a segment file name.
+func segmentBase(name string) string {
+	base, _, _ := strings.Cut(name, ".")
+	return base
+}
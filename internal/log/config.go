@@ -1,6 +1,10 @@
 package log
 
-import "github.com/hashicorp/raft"
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+)
 
 type Config struct {
 	Raft struct {
@@ -8,10 +12,54 @@ type Config struct {
 		BindAddr    string
 		StreamLayer *StreamLayer
 		Bootstrap   bool
+		// MaxApplyLag is the number of committed entries a server may not
+		// have applied yet while it reports to be healthy, 1000 by default.
+		MaxApplyLag uint64
 	}
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// Sync selects when appended records are committed to stable
+		// storage. SyncGroup commits them every SyncInterval and once
+		// SyncBytes are pending, whichever comes first.
+		Sync         SyncMode
+		SyncInterval time.Duration
+		SyncBytes    uint64
+		// MaxOpenSegments bounds the sealed segments whose files are
+		// kept open. Only the active segment is open at all times.
+		MaxOpenSegments int
+	}
+	// Retention limits the data kept by a log. Sealed segments whose newest
+	// record is older than MaxAge, or which exceed MaxBytes in total, are
+	// removed every CheckInterval. Zero values disable the respective limit.
+	Retention struct {
+		MaxAge        time.Duration
+		MaxBytes      uint64
+		CheckInterval time.Duration
+	}
+	// Compaction keeps only the newest record of every key in the sealed
+	// segments, running every Interval. Tombstones, keyed records without
+	// a value, are removed once their segment is older than
+	// TombstoneRetention. A zero Interval disables compaction.
+	Compaction struct {
+		Interval           time.Duration
+		TombstoneRetention time.Duration
 	}
 }
+
+// SyncMode is the durability policy of a log.
+type SyncMode string
+
+const (
+	// SyncOS hands appended records to the operating system, which decides
+	// when to write them to disk. Records survive a crash of the process,
+	// but may be lost on power loss.
+	SyncOS SyncMode = "os"
+	// SyncAlways fsyncs every append, or batch of appends, before it is
+	// acknowledged.
+	SyncAlways SyncMode = "always"
+	// SyncGroup fsyncs appended records in groups, bounding the records
+	// lost on power loss by an interval or amount of bytes.
+	SyncGroup SyncMode = "group"
+)
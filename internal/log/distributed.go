@@ -3,27 +3,73 @@ package log
 import (
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	api "github.com/justagabriel/proglog/api/v1"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
+// applyTimeout bounds the time requests wait to be applied through raft.
+const applyTimeout = 10 * time.Second
+
+const defaultMaxApplyLag = 1000
+
 type DistributedLog struct {
 	config Config
 	log    *Log
 	raft   *raft.Raft
+	// closeRetention stops the replicated retention of the log
+	closeRetention chan struct{}
+	// compactions hands the compactions applied by the fsm to the
+	// background compaction of the replica, closeCompaction stops both
+	compactions     chan *api.CompactRequest
+	closeCompaction chan struct{}
+
+	// serversChanged is closed and replaced whenever the leader or the
+	// configuration of the cluster changes
+	serversMu      sync.Mutex
+	serversChanged chan struct{}
+	// unreachable holds the last contact of the followers the leader fails
+	// to heartbeat
+	unreachable map[raft.ServerID]time.Time
+	// labels are the labels of the servers gossiped by the membership
+	labels        map[raft.ServerID]map[string]string
+	observer      *raft.Observer
+	closeObserver chan struct{}
+
+	// matchIndex holds the index up to which the logs of the followers match
+	// the one of the leader, see matchTransport
+	matchMu    sync.Mutex
+	matchIndex map[raft.ServerID]uint64
 }
 
 func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
-	l := &DistributedLog{config: config}
+	if config.Raft.MaxApplyLag == 0 {
+		config.Raft.MaxApplyLag = defaultMaxApplyLag
+	}
+	l := &DistributedLog{
+		config:         config,
+		serversChanged: make(chan struct{}),
+		unreachable:    make(map[raft.ServerID]time.Time),
+		labels:         make(map[raft.ServerID]map[string]string),
+		matchIndex:     make(map[raft.ServerID]uint64),
+		// a pending compaction is covered by the next one, see
+		// queueCompaction
+		compactions:     make(chan *api.CompactRequest, 1),
+		closeCompaction: make(chan struct{}),
+	}
 
 	err := l.setupLog(dataDir)
 	if err != nil {
@@ -35,6 +81,18 @@ func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
 		return nil, err
 	}
 
+	if hasRetention(config) {
+		l.closeRetention = make(chan struct{})
+		go l.log.enforceRetention(config, l.truncate, l.closeRetention)
+	}
+
+	// replicas compact when the leader asks them to, whether or not they
+	// have compaction configured themselves
+	go l.compactReplica(l.closeCompaction)
+	if config.Compaction.Interval > 0 {
+		go l.log.compactEvery(config.Compaction.Interval, l.compact, l.closeCompaction)
+	}
+
 	return l, nil
 }
 
@@ -43,13 +101,20 @@ func (l *DistributedLog) setupLog(dataDir string) error {
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return err
 	}
+	// retention and compaction are enforced through raft, so that every
+	// replica drops the same records
+	logConfig := l.config
+	logConfig.Retention.MaxAge = 0
+	logConfig.Retention.MaxBytes = 0
+	logConfig.Compaction.Interval = 0
+
 	var err error
-	l.log, err = NewLog(logDir, l.config)
+	l.log, err = NewLog(logDir, logConfig)
 	return err
 }
 
 func (l *DistributedLog) setupRaft(dataDir string) error {
-	fsm := &fsm{log: l.log}
+	fsm := &fsm{log: l.log, configured: l.notifyServers, compact: l.queueCompaction}
 
 	logDir := filepath.Join(dataDir, "raft", "log")
 	err := os.MkdirAll(logDir, 0755)
@@ -57,8 +122,14 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 		return err
 	}
 
+	// the log store keeps the configured sync mode, so raft acknowledges
+	// entries only once they are as durable as configured
 	logConfig := l.config
 	logConfig.Segment.InitialOffset = 1
+	// raft compacts its own log through snapshots
+	logConfig.Retention.MaxAge = 0
+	logConfig.Retention.MaxBytes = 0
+	logConfig.Compaction.Interval = 0
 	logStore, err := newLogStore(logDir, logConfig)
 	if err != nil {
 		return err
@@ -79,12 +150,15 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 
 	maxPool := 5
 	timeout := 10 * time.Second
-	transport := raft.NewNetworkTransport(
-		l.config.Raft.StreamLayer,
-		maxPool,
-		timeout,
-		os.Stderr,
-	)
+	transport := &matchTransport{
+		NetworkTransport: raft.NewNetworkTransport(
+			l.config.Raft.StreamLayer,
+			maxPool,
+			timeout,
+			os.Stderr,
+		),
+		matched: l.matched,
+	}
 
 	config := raft.DefaultConfig()
 	config.LocalID = l.config.Raft.LocalID
@@ -106,6 +180,7 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 	if err != nil {
 		return err
 	}
+	l.observeRaft()
 
 	hasState, err := raft.HasExistingState(logStore, stableStore, snapshotStore)
 	if err != nil {
@@ -126,6 +201,8 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 }
 
 func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
+	// followers replicate the timestamp of the leader
+	record.Timestamp = time.Now().UnixNano()
 	res, err := l.apply(AppendRequestType, &api.CreateRecordRequest{Record: record})
 	if err != nil {
 		return 0, err
@@ -133,6 +210,20 @@ func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
 	return res.(*api.CreateRecordResponse).Offset, nil
 }
 
+// AppendBatch replicates the records as a single raft entry, so they are
+// applied together and get contiguous offsets.
+func (l *DistributedLog) AppendBatch(records []*api.Record) ([]uint64, error) {
+	now := time.Now().UnixNano()
+	for _, record := range records {
+		record.Timestamp = now
+	}
+	res, err := l.apply(AppendBatchRequestType, &api.CreateBatchRequest{Records: records})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*api.CreateBatchResponse).Offsets, nil
+}
+
 func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interface{}, error) {
 	var buf bytes.Buffer
 	_, err := buf.Write([]byte{byte(reqType)})
@@ -150,10 +241,9 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 		return nil, err
 	}
 
-	timeout := 10 * time.Second
-	future := l.raft.Apply(buf.Bytes(), timeout)
-	if future.Error() != nil {
-		return nil, future.Error()
+	future := l.raft.Apply(buf.Bytes(), applyTimeout)
+	if err = l.notLeader(future.Error()); err != nil {
+		return nil, err
 	}
 
 	res := future.Response()
@@ -165,17 +255,141 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 	return res, nil
 }
 
+// notLeader turns raft's error of requests to followers into an
+// api.ErrNotLeader, which tells clients where to find the leader.
+func (l *DistributedLog) notLeader(err error) error {
+	if errors.Is(err, raft.ErrNotLeader) {
+		// raft and RPC share their address, so clients can use it directly
+		return api.ErrNotLeader{LeaderAddr: l.leaderAddr()}
+	}
+	return err
+}
+
+// leaderAddr returns the address the leader was added to the configuration
+// with. raft reports the address the leader listens on instead, which is
+// unusable for clients if it listens on all interfaces.
+func (l *DistributedLog) leaderAddr() string {
+	leaderAddr, leaderID := l.raft.LeaderWithID()
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return string(leaderAddr)
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.ID == leaderID {
+			return string(srv.Address)
+		}
+	}
+	return string(leaderAddr)
+}
+
+// Barrier returns once all records committed before the call are applied to
+// the local replica. Only the leader can commit, so it fails with
+// api.ErrNotLeader on followers and reads following it are linearizable.
+func (l *DistributedLog) Barrier() error {
+	return l.notLeader(l.raft.Barrier(applyTimeout).Error())
+}
+
 func (l *DistributedLog) Read(offset uint64) (*api.Record, error) {
 	return l.log.Read(offset)
 }
 
-var _ raft.FSM = (*fsm)(nil)
+// LowestOffset returns the lowest offset readable from the local replica.
+func (l *DistributedLog) LowestOffset() (uint64, error) {
+	return l.log.LowestOffset()
+}
+
+// HighestOffset returns the highest offset readable from the local replica.
+func (l *DistributedLog) HighestOffset() (uint64, error) {
+	return l.log.HighestOffset()
+}
+
+// ReadRange reads a batch of records from the local replica.
+func (l *DistributedLog) ReadRange(start uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error) {
+	return l.log.ReadRange(start, maxRecords, maxBytes)
+}
+
+// Notify signals records appended to the local replica.
+func (l *DistributedLog) Notify(off uint64) <-chan struct{} {
+	return l.log.Notify(off)
+}
+
+// OffsetForTime looks up the offset of a timestamp on the local replica.
+func (l *DistributedLog) OffsetForTime(timestamp int64) (uint64, error) {
+	return l.log.OffsetForTime(timestamp)
+}
+
+// truncate replicates the removal of all records up to and including lowest.
+// Only the leader proposes the removal, followers apply it through raft.
+func (l *DistributedLog) truncate(lowest uint64) error {
+	if l.raft.State() != raft.Leader {
+		return nil
+	}
+	_, err := l.apply(TruncateRequestType, &api.TruncateRequest{Lowest: lowest})
+	return err
+}
+
+// compact asks the replicas to compact the records the leader holds, and to
+// remove the tombstones past the retention by the leader's clock.
+func (l *DistributedLog) compact(now time.Time) error {
+	if l.raft.State() != raft.Leader {
+		return nil
+	}
+	highest, err := l.log.HighestOffset()
+	if err != nil {
+		return err
+	}
+	_, err = l.apply(CompactRequestType, &api.CompactRequest{
+		UpTo:             highest + 1,
+		TombstonesBefore: now.Add(-l.config.Compaction.TombstoneRetention).UnixNano(),
+	})
+	return err
+}
+
+// queueCompaction hands req to compactReplica without blocking the fsm. A
+// pending compaction is replaced, as req compacts at least the same records.
+func (l *DistributedLog) queueCompaction(req *api.CompactRequest) {
+	for {
+		select {
+		case l.compactions <- req:
+			return
+		default:
+		}
+		select {
+		case <-l.compactions:
+		default:
+		}
+	}
+}
+
+// compactReplica compacts the local replica as the fsm applies compactions,
+// until done is closed.
+func (l *DistributedLog) compactReplica(done <-chan struct{}) {
+	logger := zap.L().Named("log")
+	for {
+		select {
+		case <-done:
+			return
+		case req := <-l.compactions:
+			if err := l.log.compactReplica(req.UpTo, req.TombstonesBefore); err != nil {
+				logger.Error("failed to compact log", zap.Error(err))
+			}
+		}
+	}
+}
+
+var _ raft.ConfigurationStore = (*fsm)(nil)
 
 type fsm struct {
 	log *Log
+	// configured is called once a configuration change is committed
+	configured func()
+	// compact is called with the compactions to run in the background
+	compact func(req *api.CompactRequest)
 }
 
-func (l *DistributedLog) Join(id, addr string) error {
+// Join adds a server to the cluster. Non-voters replicate the log without
+// counting towards the quorum, so they scale reads without slowing commits.
+func (l *DistributedLog) Join(id, addr string, voter bool) error {
 	configFuture := l.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
 		return err
@@ -183,20 +397,29 @@ func (l *DistributedLog) Join(id, addr string) error {
 
 	serverID := raft.ServerID(id)
 	serverAddr := raft.ServerAddress(addr)
+	suffrage := raft.Nonvoter
+	if voter {
+		suffrage = raft.Voter
+	}
 	for _, srv := range configFuture.Configuration().Servers {
 		if srv.ID == serverID || srv.Address == serverAddr {
-			if srv.ID == serverID && srv.Address == serverAddr {
+			if srv.ID == serverID && srv.Address == serverAddr && srv.Suffrage == suffrage {
 				// server has already joined
 				return nil
 			}
-			// remove existing server
+			// remove existing server, which also changes its suffrage
 			removeFuture := l.raft.RemoveServer(serverID, 0, 0)
 			if err := removeFuture.Error(); err != nil {
 				return err
 			}
 		}
 	}
-	addFuture := l.raft.AddVoter(serverID, serverAddr, 0, 0)
+	var addFuture raft.IndexFuture
+	if voter {
+		addFuture = l.raft.AddVoter(serverID, serverAddr, 0, 0)
+	} else {
+		addFuture = l.raft.AddNonvoter(serverID, serverAddr, 0, 0)
+	}
 	if err := addFuture.Error(); err != nil {
 		return err
 	}
@@ -226,6 +449,20 @@ func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
 
 // Close disconnects from the Raft cluster and shut's down the replication service.
 func (l *DistributedLog) Close() error {
+	if l.closeRetention != nil {
+		close(l.closeRetention)
+		l.closeRetention = nil
+	}
+	if l.closeCompaction != nil {
+		close(l.closeCompaction)
+		l.closeCompaction = nil
+	}
+	if l.observer != nil {
+		l.raft.DeregisterObserver(l.observer)
+		close(l.closeObserver)
+		l.observer = nil
+	}
+
 	f := l.raft.Shutdown()
 	if err := f.Error(); err != nil {
 		return err
@@ -239,21 +476,260 @@ func (l *DistributedLog) GetServers() ([]*api.Server, error) {
 	if err := future.Error(); err != nil {
 		return nil, err
 	}
+	l.serversMu.Lock()
+	defer l.serversMu.Unlock()
+	// raft knows the leader by its listener address, which may differ from
+	// the address it was added with, so the leader is matched by its ID
+	_, leaderID := l.raft.LeaderWithID()
 	var servers []*api.Server
 	for _, srv := range future.Configuration().Servers {
-		servers = append(servers, &api.Server{
+		server := &api.Server{
 			Id:       string(srv.ID),
 			RpcAddr:  string(srv.Address),
-			IsLeader: l.raft.Leader() == srv.Address,
-		})
+			IsLeader: leaderID != "" && leaderID == srv.ID,
+			Suffrage: suffrage(srv.Suffrage),
+			Labels:   l.labels[srv.ID],
+		}
+		if !server.IsLeader {
+			server.LagMs = l.lag(srv.ID)
+			server.LagEntries = l.lagEntries(srv.ID)
+		}
+		servers = append(servers, server)
 	}
 	return servers, nil
 }
 
+// lag returns the milliseconds since the follower id was last in contact with
+// the leader. Followers know their own lag, the leader knows the lag of the
+// followers it fails to reach. The caller must hold serversMu.
+func (l *DistributedLog) lag(id raft.ServerID) uint64 {
+	lastContact, ok := l.unreachable[id]
+	if id == l.config.Raft.LocalID {
+		lastContact, ok = l.raft.LastContact(), true
+	}
+	if !ok || lastContact.IsZero() {
+		return 0
+	}
+	return uint64(time.Since(lastContact).Milliseconds())
+}
+
+// lagEntries returns the number of committed entries the follower id is yet
+// to replicate. The leader knows how far the logs of its followers match its
+// own, followers know the entries they are yet to apply and 0 is returned for
+// the others.
+func (l *DistributedLog) lagEntries(id raft.ServerID) uint64 {
+	var replicated uint64
+	switch {
+	case id == l.config.Raft.LocalID:
+		replicated = l.raft.AppliedIndex()
+	case l.raft.State() == raft.Leader:
+		l.matchMu.Lock()
+		index, ok := l.matchIndex[id]
+		l.matchMu.Unlock()
+		if !ok {
+			return 0
+		}
+		replicated = index
+	default:
+		return 0
+	}
+	if commit := l.raft.CommitIndex(); commit > replicated {
+		return commit - replicated
+	}
+	return 0
+}
+
+// matched records that the log of the follower id matches the one of the
+// leader up to index.
+func (l *DistributedLog) matched(id raft.ServerID, index uint64) {
+	l.matchMu.Lock()
+	defer l.matchMu.Unlock()
+	// heartbeats report 0, the index of the last entries sent is kept
+	l.matchIndex[id] = max(l.matchIndex[id], index)
+}
+
+// Health reports whether the server knows a leader, whether it's the leader
+// itself and whether it applied the committed entries but MaxApplyLag of
+// them, e.g. after a restart or while it restores a snapshot.
+func (l *DistributedLog) Health() (hasLeader, isLeader, caughtUp bool) {
+	_, leaderID := l.raft.LeaderWithID()
+	caughtUp = l.raft.CommitIndex() <= l.raft.AppliedIndex()+l.config.Raft.MaxApplyLag
+	return leaderID != "", leaderID == l.config.Raft.LocalID, caughtUp
+}
+
+// HealthChanged returns a channel which is closed by the next change of the
+// leader, see ServersChanged.
+func (l *DistributedLog) HealthChanged() <-chan struct{} {
+	return l.ServersChanged()
+}
+
+// SetLabels implements discovery.Labeler.
+func (l *DistributedLog) SetLabels(id string, labels map[string]string) {
+	l.serversMu.Lock()
+	if maps.Equal(l.labels[raft.ServerID(id)], labels) {
+		l.serversMu.Unlock()
+		return
+	}
+	if labels == nil {
+		delete(l.labels, raft.ServerID(id))
+	} else {
+		l.labels[raft.ServerID(id)] = labels
+	}
+	l.serversMu.Unlock()
+	l.notifyServers()
+}
+
+// ServersChanged returns a channel which is closed by the next change of the
+// leader or the configuration of the cluster, e.g. to watch GetServers.
+func (l *DistributedLog) ServersChanged() <-chan struct{} {
+	l.serversMu.Lock()
+	defer l.serversMu.Unlock()
+	return l.serversChanged
+}
+
+func (l *DistributedLog) notifyServers() {
+	l.serversMu.Lock()
+	defer l.serversMu.Unlock()
+	close(l.serversChanged)
+	l.serversChanged = make(chan struct{})
+}
+
+// observeRaft notifies the watchers of the servers about leader changes and
+// followers the leader fails or resumes to reach. Configuration changes are
+// reported by the fsm once they are committed.
+func (l *DistributedLog) observeRaft() {
+	observations := make(chan raft.Observation, 16)
+	l.observer = raft.NewObserver(observations, true, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation:
+			return true
+		}
+		return false
+	})
+	l.raft.RegisterObserver(l.observer)
+	l.closeObserver = make(chan struct{})
+
+	go func(done <-chan struct{}) {
+		for {
+			select {
+			case <-done:
+				return
+			case o := <-observations:
+				if l.observe(o) {
+					l.notifyServers()
+				}
+			}
+		}
+	}(l.closeObserver)
+}
+
+// observe records o and reports whether it changes the servers.
+func (l *DistributedLog) observe(o raft.Observation) bool {
+	l.serversMu.Lock()
+	defer l.serversMu.Unlock()
+	switch data := o.Data.(type) {
+	case raft.FailedHeartbeatObservation:
+		// the leader keeps retrying, only the first failure is news
+		if _, ok := l.unreachable[data.PeerID]; ok {
+			return false
+		}
+		l.unreachable[data.PeerID] = data.LastContact
+	case raft.ResumedHeartbeatObservation:
+		delete(l.unreachable, data.PeerID)
+	case raft.LeaderObservation:
+		// only the leader heartbeats the followers and replicates its log
+		clear(l.unreachable)
+		l.matchMu.Lock()
+		clear(l.matchIndex)
+		l.matchMu.Unlock()
+	}
+	return true
+}
+
+// RaftState describes the raft state of this server and its configuration.
+func (l *DistributedLog) RaftState() (*api.GetRaftStateResponse, error) {
+	servers, err := l.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	term, err := strconv.ParseUint(l.raft.Stats()["term"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetRaftStateResponse{
+		State:        l.raft.State().String(),
+		Term:         term,
+		LastIndex:    l.raft.LastIndex(),
+		CommitIndex:  l.raft.CommitIndex(),
+		AppliedIndex: l.raft.AppliedIndex(),
+		Peers:        servers,
+	}, nil
+}
+
+// AddPeer adds a server to the cluster like Join, with the errors of Apply.
+func (l *DistributedLog) AddPeer(id, addr string, voter bool) error {
+	return l.notLeader(l.Join(id, addr, voter))
+}
+
+// RemovePeer removes a server from the cluster like Leave, with the errors
+// of Apply.
+func (l *DistributedLog) RemovePeer(id string) error {
+	return l.notLeader(l.Leave(id))
+}
+
+// TransferLeadership hands the leadership to the server with the given id,
+// or to the most up to date server if id is empty.
+func (l *DistributedLog) TransferLeadership(id string) error {
+	if id == "" {
+		return l.notLeader(l.raft.LeadershipTransfer().Error())
+	}
+
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.ID == raft.ServerID(id) {
+			return l.notLeader(l.raft.LeadershipTransferToServer(srv.ID, srv.Address).Error())
+		}
+	}
+	return fmt.Errorf("unknown server %q", id)
+}
+
+// Snapshot takes a raft snapshot of the local replica and returns the index
+// and term of the last entry it covers.
+func (l *DistributedLog) Snapshot() (index, term uint64, err error) {
+	future := l.raft.Snapshot()
+	if err = future.Error(); err != nil {
+		return 0, 0, err
+	}
+	meta, r, err := future.Open()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	return meta.Index, meta.Term, nil
+}
+
+// Segments describes the segments of the local replica.
+func (l *DistributedLog) Segments() []*api.Segment {
+	return l.log.Segments()
+}
+
+func suffrage(s raft.ServerSuffrage) api.Suffrage {
+	if s == raft.Nonvoter {
+		return api.Suffrage_SUFFRAGE_NONVOTER
+	}
+	return api.Suffrage_SUFFRAGE_VOTER
+}
+
 type RequestType uint8
 
 const (
-	AppendRequestType RequestType = 0
+	AppendRequestType      RequestType = 0
+	TruncateRequestType    RequestType = 1
+	AppendBatchRequestType RequestType = 2
+	CompactRequestType     RequestType = 3
 )
 
 // Apply implements raft.FSM.
@@ -263,6 +739,12 @@ func (l *fsm) Apply(record *raft.Log) interface{} {
 	switch reqType {
 	case AppendRequestType:
 		return l.applyAppend(buf[1:])
+	case TruncateRequestType:
+		return l.applyTruncate(buf[1:])
+	case AppendBatchRequestType:
+		return l.applyAppendBatch(buf[1:])
+	case CompactRequestType:
+		return l.applyCompact(buf[1:])
 	}
 	return nil
 }
@@ -282,16 +764,66 @@ func (l *fsm) applyAppend(b []byte) interface{} {
 	}
 }
 
+func (l *fsm) applyAppendBatch(b []byte) interface{} {
+	var req api.CreateBatchRequest
+	err := proto.Unmarshal(b, &req)
+	if err != nil {
+		return err
+	}
+	offsets, err := l.log.AppendBatch(req.Records)
+	if err != nil {
+		return err
+	}
+	return &api.CreateBatchResponse{
+		Offsets: offsets,
+	}
+}
+
+func (l *fsm) applyTruncate(b []byte) interface{} {
+	var req api.TruncateRequest
+	err := proto.Unmarshal(b, &req)
+	if err != nil {
+		return err
+	}
+	return l.log.Truncate(req.Lowest)
+}
+
+// applyCompact leaves the compaction to the background, as it rewrites whole
+// segments. The bounds of req make it compact the same records whenever it
+// runs.
+func (l *fsm) applyCompact(b []byte) interface{} {
+	var req api.CompactRequest
+	err := proto.Unmarshal(b, &req)
+	if err != nil {
+		return err
+	}
+	if l.compact != nil {
+		l.compact(&req)
+	}
+	return nil
+}
+
+// StoreConfiguration implements raft.ConfigurationStore. The configuration
+// itself is kept by raft, so the fsm only reports the change.
+func (f *fsm) StoreConfiguration(index uint64, configuration raft.Configuration) {
+	if f.configured != nil {
+		f.configured()
+	}
+}
+
 // Snapshot implements raft.FSM.
 func (m *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	r := m.log.Reader()
+	r, err := m.log.Reader()
+	if err != nil {
+		return nil, err
+	}
 	return &snapshot{reader: r}, nil
 }
 
 var _ raft.FSMSnapshot = (*snapshot)(nil)
 
 type snapshot struct {
-	reader io.Reader
+	reader io.ReadCloser
 }
 
 // Persist implements raft.FSMSnapshot.
@@ -303,14 +835,16 @@ func (s *snapshot) Persist(sink raft.SnapshotSink) error {
 	return sink.Close()
 }
 
-// Release implements raft.FSMSnapshot.
-func (*snapshot) Release() {}
+// Release implements raft.FSMSnapshot. It closes the files of the log which
+// the snapshot kept open.
+func (s *snapshot) Release() {
+	_ = s.reader.Close()
+}
 
 func (f *fsm) Restore(r io.ReadCloser) error {
-	b := make([]byte, lenWidth)
-	var buf bytes.Buffer
+	records := newRecordReader(r)
 	for i := 0; ; i++ {
-		_, err := io.ReadFull(r, b)
+		b, err := records.Next()
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -318,13 +852,8 @@ func (f *fsm) Restore(r io.ReadCloser) error {
 			return err
 		}
 
-		size := int64(enc.Uint64(b))
-		if _, err = io.CopyN(&buf, r, size); err != nil {
-			return err
-		}
-
 		record := &api.Record{}
-		if err = proto.Unmarshal(buf.Bytes(), record); err != nil {
+		if err = proto.Unmarshal(b, record); err != nil {
 			return err
 		}
 
@@ -334,10 +863,10 @@ func (f *fsm) Restore(r io.ReadCloser) error {
 				return err
 			}
 		}
-		if _, err = f.log.Append(record); err != nil {
+		// keep the offsets of compacted logs
+		if _, err = f.log.appendAt(record); err != nil {
 			return err
 		}
-		buf.Reset()
 	}
 
 	return nil
@@ -354,6 +883,8 @@ func newLogStore(dir string, c Config) (*logStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	// the latencies of the data log already cover replicated records
+	log.timed = false
 	return &logStore{log}, nil
 }
 
@@ -384,18 +915,16 @@ func (l *logStore) StoreLog(record *raft.Log) error {
 }
 
 func (l *logStore) StoreLogs(records []*raft.Log) error {
+	apiRecs := make([]*api.Record, 0, len(records))
 	for _, record := range records {
-		apiRec := &api.Record{
+		apiRecs = append(apiRecs, &api.Record{
 			Value: record.Data,
 			Term:  record.Term,
 			Type:  uint32(record.Type),
-		}
-		_, err := l.Append(apiRec)
-		if err != nil {
-			return err
-		}
+		})
 	}
-	return nil
+	_, err := l.AppendBatch(apiRecs)
+	return err
 }
 
 // DeleteRange implements raft.LogStore.
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -11,52 +12,16 @@ import (
 	"github.com/hashicorp/raft"
 	api "github.com/justagabriel/proglog/api/v1"
 	"github.com/justagabriel/proglog/internal"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestMultipleNodes(t *testing.T) {
 	// arrange
-	var logs []*DistributedLog
 	nodeCount := 3
-
-	for i := 0; i < nodeCount; i++ {
-		dataDir := internal.GetTempDir(t, "distributed-log-test")
-		defer func(dir string) {
-			_ = os.RemoveAll(dir)
-		}(dataDir)
-
-		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", internal.FreePort(t)))
-		require.NoError(t, err)
-
-		config := Config{}
-		config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
-		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
-		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
-		config.Raft.ElectionTimeout = 50 * time.Millisecond
-		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
-		config.Raft.CommitTimeout = 5 * time.Millisecond
-		config.Raft.BindAddr = ln.Addr().String()
-
-		if i == 0 {
-			config.Raft.Bootstrap = true
-		}
-
-		dlog, err := NewDistributedLog(dataDir, config)
-		require.NoError(t, err)
-
-		if i != 0 {
-			err = logs[0].Join(
-				fmt.Sprintf("%d", i),
-				ln.Addr().String(),
-			)
-			require.NoError(t, err)
-		} else {
-			err = dlog.WaitForLeader(3 * time.Second)
-			require.NoError(t, err)
-		}
-
-		logs = append(logs, dlog)
-	}
+	logs := setupDistributedLogs(t, nodeCount, nil)
 
 	records := []*api.Record{
 		{Value: []byte("first")},
@@ -80,6 +45,10 @@ func TestMultipleNodes(t *testing.T) {
 					if !reflect.DeepEqual(got.Value, r.Value) {
 						return false
 					}
+					// followers keep the timestamp of the leader
+					if got.Timestamp == 0 || got.Timestamp != r.Timestamp {
+						return false
+					}
 				}
 				return true
 			},
@@ -95,6 +64,12 @@ func TestMultipleNodes(t *testing.T) {
 	require.False(t, servers[1].IsLeader)
 	require.False(t, servers[2].IsLeader)
 
+	// followers point writers to the leader
+	_, err = logs[1].Append(&api.Record{Value: []byte("misdirected")})
+	require.Equal(t, api.ErrNotLeader{LeaderAddr: servers[0].RpcAddr}, err)
+	require.Equal(t, api.ErrNotLeader{LeaderAddr: servers[0].RpcAddr}, logs[1].Barrier())
+	require.NoError(t, logs[0].Barrier())
+
 	err = logs[0].Leave("1")
 	require.NoError(t, err)
 
@@ -122,3 +97,412 @@ func TestMultipleNodes(t *testing.T) {
 	require.Equal(t, []byte("third"), record.Value)
 	require.Equal(t, off, record.Offset)
 }
+
+func TestDistributedNonvoter(t *testing.T) {
+	logs := setupDistributedLogs(t, 2, nil)
+
+	servers, err := logs[0].GetServers()
+	require.NoError(t, err)
+	require.Equal(t, api.Suffrage_SUFFRAGE_VOTER, servers[1].Suffrage)
+
+	// joining again with another role changes the suffrage
+	require.NoError(t, logs[0].Join(servers[1].Id, servers[1].RpcAddr, false))
+	servers, err = logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+	require.Equal(t, api.Suffrage_SUFFRAGE_VOTER, servers[0].Suffrage)
+	require.Equal(t, api.Suffrage_SUFFRAGE_NONVOTER, servers[1].Suffrage)
+
+	off, err := logs[0].Append(&api.Record{Value: []byte("replicated")})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		record, err := logs[1].Read(off)
+		return err == nil && string(record.Value) == "replicated"
+	}, 500*time.Millisecond, 50*time.Millisecond)
+}
+
+func TestDistributedLeaderAddr(t *testing.T) {
+	// arrange
+	logs := setupDistributedLogs(t, 2, func(config *Config) {
+		if config.Raft.LocalID != "0" {
+			return
+		}
+		// the leader listens on all interfaces, but is added by its address
+		sl := config.Raft.StreamLayer
+		_, port, err := net.SplitHostPort(sl.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, sl.Close())
+		ln, err := net.Listen("tcp", net.JoinHostPort("0.0.0.0", port))
+		require.NoError(t, err)
+		config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+	})
+	leaderAddr := logs[0].config.Raft.BindAddr
+
+	// act
+	var notLeader api.ErrNotLeader
+	require.Eventually(t, func() bool {
+		_, err := logs[1].Append(&api.Record{Value: []byte("follower")})
+		return errors.As(err, &notLeader) && notLeader.LeaderAddr != ""
+	}, time.Second, 10*time.Millisecond)
+	servers, err := logs[1].GetServers()
+
+	// assert
+	require.Equal(t, leaderAddr, notLeader.LeaderAddr)
+	require.NoError(t, err)
+	require.True(t, servers[0].IsLeader)
+	require.Equal(t, leaderAddr, servers[0].RpcAddr)
+	require.False(t, servers[1].IsLeader)
+}
+
+func TestDistributedAdmin(t *testing.T) {
+	logs := setupDistributedLogs(t, 3, nil)
+	_, err := logs[0].Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+
+	state, err := logs[0].RaftState()
+	require.NoError(t, err)
+	require.Equal(t, "Leader", state.State)
+	require.Len(t, state.Peers, 3)
+	require.NotZero(t, state.Term)
+	require.NotZero(t, state.AppliedIndex)
+
+	index, _, err := logs[0].Snapshot()
+	require.NoError(t, err)
+	require.NotZero(t, index)
+	require.NotEmpty(t, logs[0].Segments())
+
+	// configuration changes are only made by the leader
+	err = logs[1].RemovePeer("2")
+	require.Equal(t, api.ErrNotLeader{LeaderAddr: state.Peers[0].RpcAddr}, err)
+
+	require.NoError(t, logs[0].TransferLeadership("1"))
+	require.Eventually(t, func() bool {
+		state, err := logs[1].RaftState()
+		return err == nil && state.State == "Leader"
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestDistributedServersChanged(t *testing.T) {
+	logs := setupDistributedLogs(t, 2, nil)
+
+	// followers are told about configuration changes as well
+	// followers know their own lag
+	require.Eventually(t, func() bool {
+		servers := mustServers(t, logs[1])
+		return len(servers) == 2 && servers[0].LagMs == 0 && servers[1].LagMs < 1000
+	}, time.Second, 50*time.Millisecond)
+
+	addr := mustServers(t, logs[0])[1].RpcAddr
+	changed := logs[1].ServersChanged()
+	require.NoError(t, logs[0].Join("1", addr, false))
+	requireClosed(t, changed)
+	require.NoError(t, logs[0].Join("1", addr, true))
+
+	changed = logs[1].ServersChanged()
+	require.NoError(t, logs[0].TransferLeadership("1"))
+	requireClosed(t, changed)
+
+	// the membership gossips the labels of the servers
+	changed = logs[0].ServersChanged()
+	logs[0].SetLabels("1", map[string]string{"zone": "eu-west-1b"})
+	requireClosed(t, changed)
+	require.Equal(t, map[string]string{"zone": "eu-west-1b"}, mustServers(t, logs[0])[1].Labels)
+}
+
+func TestDistributedLagEntries(t *testing.T) {
+	// arrange
+	logs := setupDistributedLogs(t, 3, nil)
+	_, err := logs[0].Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		servers := mustServers(t, logs[0])
+		assert.Zero(c, servers[1].LagEntries)
+		assert.Zero(c, servers[2].LagEntries)
+	}, time.Second, 50*time.Millisecond)
+
+	// act
+	require.NoError(t, logs[2].raft.Shutdown().Error())
+	for i := 0; i < 5; i++ {
+		_, err := logs[0].Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// assert
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		servers := mustServers(t, logs[0])
+		assert.Zero(c, servers[1].LagEntries)
+		assert.GreaterOrEqual(c, servers[2].LagEntries, uint64(5))
+	}, time.Second, 50*time.Millisecond)
+	// followers only know their own lag
+	servers := mustServers(t, logs[1])
+	require.Zero(t, servers[2].LagEntries)
+}
+
+func TestDistributedHealth(t *testing.T) {
+	logs := setupDistributedLogs(t, 2, nil)
+
+	hasLeader, isLeader, caughtUp := logs[0].Health()
+	require.True(t, hasLeader)
+	require.True(t, isLeader)
+	require.True(t, caughtUp)
+	require.Eventually(t, func() bool {
+		hasLeader, isLeader, caughtUp := logs[1].Health()
+		return hasLeader && !isLeader && caughtUp
+	}, time.Second, 50*time.Millisecond)
+
+	// followers are told about leader changes
+	changed := logs[1].HealthChanged()
+	require.NoError(t, logs[0].TransferLeadership("1"))
+	requireClosed(t, changed)
+	require.Eventually(t, func() bool {
+		_, isLeader, _ := logs[1].Health()
+		return isLeader
+	}, time.Second, 50*time.Millisecond)
+}
+
+func mustServers(t *testing.T, l *DistributedLog) []*api.Server {
+	t.Helper()
+	servers, err := l.GetServers()
+	require.NoError(t, err)
+	return servers
+}
+
+func requireClosed(t *testing.T, c <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(3 * time.Second):
+		t.Fatal("channel wasn't closed")
+	}
+}
+
+func TestDistributedAppendBatch(t *testing.T) {
+	nodeCount := 3
+	logs := setupDistributedLogs(t, nodeCount, nil)
+
+	records := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+		{Value: []byte("third")},
+	}
+	offsets, err := logs[0].AppendBatch(records)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2}, offsets)
+
+	require.Eventually(t, func() bool {
+		for _, l := range logs {
+			for i, off := range offsets {
+				got, err := l.Read(off)
+				if err != nil || !reflect.DeepEqual(got.Value, records[i].Value) {
+					return false
+				}
+			}
+		}
+		return true
+	}, 500*time.Millisecond, 50*time.Millisecond)
+}
+
+func TestDistributedRetention(t *testing.T) {
+	logs := setupDistributedLogs(t, 2, func(c *Config) {
+		c.Segment.MaxStoreBytes = 32
+		c.Retention.MaxBytes = 1
+		c.Retention.CheckInterval = 10 * time.Millisecond
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := logs[0].Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// every record fills a segment, only the active one is kept everywhere
+	require.Eventually(t, func() bool {
+		for _, l := range logs {
+			off, err := l.LowestOffset()
+			if err != nil || off != 3 {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestDistributedCompaction(t *testing.T) {
+	// arrange
+	logs := setupDistributedLogs(t, 3, func(c *Config) {
+		// every record fills a segment, so all but the last are sealed
+		c.Segment.MaxStoreBytes = 1
+		// followers compact as the leader asks them to
+		if c.Raft.LocalID == "0" {
+			c.Compaction.Interval = 10 * time.Millisecond
+		}
+	})
+
+	// act
+	for _, record := range []*api.Record{
+		{Key: []byte("a"), Value: []byte("a1")},
+		{Key: []byte("b"), Value: []byte("b1")},
+		{Key: []byte("a"), Value: []byte("a2")},
+		// tombstone
+		{Key: []byte("b")},
+		{Key: []byte("c"), Value: []byte("c1")},
+	} {
+		_, err := logs[0].Append(record)
+		require.NoError(t, err)
+	}
+
+	// assert
+	// every replica keeps the same records
+	want := map[uint64]uint64{0: 2, 1: 2, 2: 2, 3: 4, 4: 4}
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		for _, l := range logs {
+			for off, wantOff := range want {
+				record, err := l.Read(off)
+				if assert.NoError(c, err) {
+					assert.Equal(c, wantOff, record.Offset)
+				}
+			}
+		}
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestDistributedLatencies(t *testing.T) {
+	// arrange
+	logs := setupDistributedLogs(t, 1, nil)
+	require.NoError(t, view.Register(Views...))
+	defer view.Unregister(Views...)
+
+	// act
+	_, err := logs[0].Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// assert
+	// raft's log store doesn't count the replicated record again
+	rows, err := view.RetrieveData(appendLatency.Name())
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, int64(1), rows[0].Data.(*view.DistributionData).Count)
+}
+
+func TestFSMRestoreMixedFormats(t *testing.T) {
+	// arrange
+	dir := internal.GetTempDir(t, "restore-test")
+	defer os.RemoveAll(dir)
+
+	// a log written before stores had a header, whose first segment is
+	// compacted into the current format
+	writeLegacySegment(t, dir,
+		&api.Record{Key: []byte("a"), Value: []byte("a1"), Offset: 0},
+		&api.Record{Key: []byte("a"), Value: []byte("a2"), Offset: 1},
+	)
+	writeLegacySegment(t, dir,
+		&api.Record{Value: []byte("b"), Offset: 2},
+		&api.Record{Value: []byte("c"), Offset: 3},
+	)
+	writeLegacySegment(t, dir, &api.Record{Value: []byte("d"), Offset: 4})
+
+	source, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer source.Close()
+	require.NoError(t, source.Compact())
+	require.Equal(t, storeVersionCRC, source.segments[0].storeFormat())
+	require.Equal(t, storeVersionLegacy, source.segments[1].storeFormat())
+
+	targetDir := internal.GetTempDir(t, "restore-test")
+	defer os.RemoveAll(targetDir)
+	target, err := NewLog(targetDir, Config{})
+	require.NoError(t, err)
+	defer target.Close()
+
+	// act
+	r, err := source.Reader()
+	require.NoError(t, err)
+	err = (&fsm{log: target}).Restore(r)
+	require.NoError(t, err)
+
+	// assert
+	for off, value := range map[uint64]string{1: "a2", 2: "b", 3: "c", 4: "d"} {
+		record, err := target.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, value, string(record.Value))
+	}
+}
+
+// writeLegacySegment writes the records to a segment without a store header
+// and checksums, based at the offset of the first record.
+func writeLegacySegment(t *testing.T, dir string, records ...*api.Record) {
+	t.Helper()
+
+	baseOffset := records[0].Offset
+	var store, index []byte
+	for _, record := range records {
+		entry := make([]byte, entWidth)
+		enc.PutUint32(entry, uint32(record.Offset-baseOffset))
+		enc.PutUint64(entry[offWidth:], uint64(len(store)))
+		index = append(index, entry...)
+
+		p, err := proto.Marshal(record)
+		require.NoError(t, err)
+		store = enc.AppendUint64(store, uint64(len(p)))
+		store = append(store, p...)
+	}
+
+	storePath, indexPath, _ := segmentPaths(dir, baseOffset, "")
+	require.NoError(t, os.WriteFile(storePath, store, 0644))
+	require.NoError(t, os.WriteFile(indexPath, index, 0644))
+}
+
+// setupDistributedLogs creates a cluster of nodeCount distributed logs,
+// the first of which is the leader. fn may adjust the config of every node.
+func setupDistributedLogs(t *testing.T, nodeCount int, fn func(*Config)) []*DistributedLog {
+	t.Helper()
+
+	var logs []*DistributedLog
+	for i := 0; i < nodeCount; i++ {
+		dataDir := internal.GetTempDir(t, "distributed-log-test")
+		t.Cleanup(func() {
+			_ = os.RemoveAll(dataDir)
+		})
+
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", internal.FreePort(t)))
+		require.NoError(t, err)
+
+		config := Config{}
+		config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		config.Raft.ElectionTimeout = 50 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.Raft.CommitTimeout = 5 * time.Millisecond
+		config.Raft.BindAddr = ln.Addr().String()
+
+		if i == 0 {
+			config.Raft.Bootstrap = true
+		}
+		if fn != nil {
+			fn(&config)
+		}
+
+		dlog, err := NewDistributedLog(dataDir, config)
+		require.NoError(t, err)
+		// raft keeps replicating until it's shut down
+		t.Cleanup(func() {
+			_ = dlog.Close()
+		})
+
+		if i != 0 {
+			err = logs[0].Join(
+				fmt.Sprintf("%d", i),
+				ln.Addr().String(),
+				true,
+			)
+			require.NoError(t, err)
+		} else {
+			err = dlog.WaitForLeader(3 * time.Second)
+			require.NoError(t, err)
+		}
+
+		logs = append(logs, dlog)
+	}
+	return logs
+}
@@ -3,6 +3,7 @@ package log
 import (
 	"io"
 	"os"
+	"sort"
 
 	"github.com/tysonmote/gommap"
 )
@@ -17,6 +18,8 @@ type index struct {
 	file *os.File
 	mmap gommap.MMap
 	size uint64
+	// sealed indexes are mapped read-only at their size
+	sealed bool
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
@@ -46,7 +49,68 @@ func newIndex(f *os.File, c Config) (*index, error) {
 	return idx, nil
 }
 
+// newSealedIndex maps the entries of a sealed segment read-only, without
+// growing the file to the maximum index size.
+func newSealedIndex(f *os.File) (*index, error) {
+	idx := &index{
+		file:   f,
+		sealed: true,
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	idx.size = uint64(fi.Size())
+	if idx.size == 0 {
+		return idx, nil
+	}
+
+	idx.mmap, err = gommap.Map(
+		idx.file.Fd(),
+		gommap.PROT_READ,
+		gommap.MAP_SHARED,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// scan drops trailing entries which were zeroed or only partially written
+// due to an unclean shutdown. Valid entries have strictly increasing offsets
+// and positions, none of which lies in front of minPos.
+func (i *index) scan(minPos uint64) {
+	limit := i.size
+	if uint64(len(i.mmap)) < limit {
+		limit = uint64(len(i.mmap))
+	}
+
+	var prevOff uint32
+	var prevPos uint64
+	i.size = 0
+	for i.size+entWidth <= limit {
+		off := enc.Uint32(i.mmap[i.size : i.size+offWidth])
+		pos := enc.Uint64(i.mmap[i.size+offWidth : i.size+entWidth])
+		if pos < minPos || (i.size > 0 && (off <= prevOff || pos <= prevPos)) {
+			break
+		}
+		prevOff, prevPos = off, pos
+		i.size += entWidth
+	}
+}
+
 func (i *index) Close() error {
+	if i.sealed {
+		if i.mmap != nil {
+			if err := i.mmap.UnsafeUnmap(); err != nil {
+				return err
+			}
+		}
+		return i.file.Close()
+	}
+
 	err := i.mmap.Sync(gommap.MS_SYNC)
 	if err != nil {
 		return err
@@ -57,6 +121,11 @@ func (i *index) Close() error {
 		return err
 	}
 
+	err = i.mmap.UnsafeUnmap()
+	if err != nil {
+		return err
+	}
+
 	err = i.file.Truncate(int64(i.size))
 	if err != nil {
 		return err
@@ -86,8 +155,29 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 	return out, pos, nil
 }
 
+// Find returns the entry of the relative offset off or, if off was compacted
+// away, the entry of the next higher offset. Entries are sorted by offset, so
+// once an index has gaps the entry is searched for.
+func (i *index) Find(off uint32) (out uint32, pos uint64, err error) {
+	n := i.size / entWidth
+	if uint64(off) < n {
+		out, pos, err = i.Read(int64(off))
+		if err == nil && out == off {
+			return out, pos, nil
+		}
+	}
+
+	entry := sort.Search(int(n), func(e int) bool {
+		return enc.Uint32(i.mmap[uint64(e)*entWidth:uint64(e)*entWidth+offWidth]) >= off
+	})
+	if uint64(entry) == n {
+		return 0, 0, io.EOF
+	}
+	return i.Read(int64(entry))
+}
+
 func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth {
+	if i.sealed || uint64(len(i.mmap)) < i.size+entWidth {
 		return io.EOF
 	}
 
@@ -97,6 +187,24 @@ func (i *index) Write(off uint32, pos uint64) error {
 	return nil
 }
 
+// truncate drops the entries at or behind off and returns the store position
+// of the first dropped one, or false if there is none.
+func (i *index) truncate(off uint32) (uint64, bool) {
+	n := i.size / entWidth
+	entry := sort.Search(int(n), func(e int) bool {
+		return enc.Uint32(i.mmap[uint64(e)*entWidth:uint64(e)*entWidth+offWidth]) >= off
+	})
+	if uint64(entry) == n {
+		return 0, false
+	}
+	_, pos, _ := i.Read(int64(entry))
+	size := uint64(entry) * entWidth
+	// recover must not mistake the dropped entries for valid ones
+	clear(i.mmap[size:i.size])
+	i.size = size
+	return pos, true
+}
+
 func (i *index) Name() string {
 	return i.file.Name()
 }
@@ -52,3 +52,30 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, uint32(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 }
+
+func TestIndexFind(t *testing.T) {
+	f := internal.GetTempFile(t, "", "index_find_test")
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	// offsets 1, 2 and 5 were compacted away
+	for _, off := range []uint32{0, 3, 4, 6} {
+		require.NoError(t, idx.Write(off, uint64(off)*10))
+	}
+
+	scenarios := map[uint32]uint32{0: 0, 1: 3, 2: 3, 3: 3, 4: 4, 5: 6, 6: 6}
+	for in, want := range scenarios {
+		out, pos, err := idx.Find(in)
+		require.NoError(t, err)
+		require.Equal(t, want, out)
+		require.Equal(t, uint64(want)*10, pos)
+	}
+
+	_, _, err = idx.Find(7)
+	require.Equal(t, io.EOF, err)
+}
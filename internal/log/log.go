@@ -1,15 +1,21 @@
 package log
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	api "github.com/justagabriel/proglog/api/v1"
+	"go.uber.org/zap"
 )
 
 type Log struct {
@@ -17,9 +23,31 @@ type Log struct {
 	Dir           string
 	Config        Config
 	activeSegment *segment
-	segments      []*segment
+	// segments are sorted by their base offsets
+	segments []*segment
+	cache    *segmentCache
+	// closeRetention stops the background retention of the log
+	closeRetention chan struct{}
+	// closeCompaction stops the background compaction of the log
+	closeCompaction chan struct{}
+	// compactMu serializes compactions, which share their temporary files
+	compactMu sync.Mutex
+	// closeSync stops the background sync of SyncGroup
+	closeSync chan struct{}
+	// appended is closed and replaced whenever records are appended, which
+	// wakes up all readers waiting for them at once
+	appended chan struct{}
+	// timed logs record the latencies of their appends and reads
+	timed bool
+	// lowest is the lowest readable offset. Truncate only removes whole
+	// segments, so the first segment may still hold records below it.
+	lowest uint64
 }
 
+// lowestFile keeps the lowest readable offset of a log, so truncated records
+// stay hidden after the log is opened again.
+const lowestFile = "lowest"
+
 func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxStoreBytes == 0 {
 		c.Segment.MaxStoreBytes = 1024
@@ -29,12 +57,56 @@ func NewLog(dir string, c Config) (*Log, error) {
 		c.Segment.MaxIndexBytes = 1024
 	}
 
+	if c.Segment.MaxOpenSegments == 0 {
+		c.Segment.MaxOpenSegments = 64
+	}
+
+	switch c.Segment.Sync {
+	case "":
+		c.Segment.Sync = SyncOS
+	case SyncOS, SyncAlways:
+	case SyncGroup:
+		if c.Segment.SyncInterval == 0 && c.Segment.SyncBytes == 0 {
+			c.Segment.SyncInterval = time.Second
+		}
+	default:
+		return nil, fmt.Errorf("unknown sync mode %q", c.Segment.Sync)
+	}
+
 	l := &Log{
-		Dir:    dir,
-		Config: c,
+		Dir:      dir,
+		Config:   c,
+		appended: make(chan struct{}),
+		timed:    true,
+	}
+
+	err := l.setup()
+	if err != nil {
+		return nil, err
 	}
 
-	return l, l.setup()
+	l.start()
+	return l, nil
+}
+
+// start runs the background retention, compaction and sync of the log as
+// configured, until the log is closed.
+func (l *Log) start() {
+	c := l.Config
+	if hasRetention(c) {
+		l.closeRetention = make(chan struct{})
+		go l.enforceRetention(c, l.Truncate, l.closeRetention)
+	}
+
+	if c.Compaction.Interval > 0 {
+		l.closeCompaction = make(chan struct{})
+		go l.compactEvery(c.Compaction.Interval, l.compact, l.closeCompaction)
+	}
+
+	if c.Segment.Sync == SyncGroup && c.Segment.SyncInterval > 0 {
+		l.closeSync = make(chan struct{})
+		go l.syncEvery(c.Segment.SyncInterval, l.closeSync)
+	}
 }
 
 func (l *Log) newSegment(off uint64) error {
@@ -54,8 +126,16 @@ func (l *Log) setup() error {
 		return err
 	}
 
+	files, err = l.finishCompaction(files)
+	if err != nil {
+		return err
+	}
+
 	var baseOffsets []uint64
 	for _, file := range files {
+		if ext := path.Ext(file.Name()); ext != ".store" && ext != ".index" {
+			continue
+		}
 		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
 		off, _ := strconv.ParseUint(offStr, 10, 0)
 		baseOffsets = append(baseOffsets, off)
@@ -65,13 +145,24 @@ func (l *Log) setup() error {
 		return baseOffsets[i] < baseOffsets[j]
 	})
 
-	for idx := range baseOffsets {
-		if err = l.newSegment(baseOffsets[idx]); err != nil {
+	// baseOffsets contains dups for index and store files
+	baseOffsets = slices.Compact(baseOffsets)
+
+	l.segments = nil
+	l.cache = newSegmentCache(l.Config.Segment.MaxOpenSegments)
+	for idx, baseOffset := range baseOffsets {
+		if idx == len(baseOffsets)-1 {
+			if err = l.newSegment(baseOffset); err != nil {
+				return err
+			}
+			break
+		}
+		// sealed segments are opened when they are read
+		s, err := loadSegment(l.Dir, baseOffset, l.Config)
+		if err != nil {
 			return err
 		}
-		// baseOffset contains dup for index and store so we skip
-		// the dup
-		idx++
+		l.segments = append(l.segments, s)
 	}
 
 	if l.segments == nil {
@@ -81,49 +172,334 @@ func (l *Log) setup() error {
 		}
 	}
 
-	return nil
+	l.lowest, err = readLowest(l.Dir)
+	return err
+}
+
+// readLowest returns the lowest readable offset kept in dir, or 0 if the log
+// was never truncated.
+func readLowest(dir string) (uint64, error) {
+	b, err := os.ReadFile(path.Join(dir, lowestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, fmt.Errorf("invalid lowest offset file of %d bytes", len(b))
+	}
+	return enc.Uint64(b), nil
+}
+
+// writeLowest keeps lowest in dir. The file is replaced atomically, so it
+// holds either the previous or the new offset after a crash.
+func writeLowest(dir string, lowest uint64) error {
+	b := make([]byte, 8)
+	enc.PutUint64(b, lowest)
+
+	name := path.Join(dir, lowestFile)
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
 }
 
 func (l *Log) Append(record *api.Record) (uint64, error) {
+	defer l.recordSince(appendLatency, time.Now())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.notifyAppended()
+
+	record.Offset = l.activeSegment.nextOffset
+	off, err := l.append(record)
+	if err != nil {
+		return 0, err
+	}
+	return off, l.commit(l.activeSegment, false)
+}
+
+// AppendBatch appends the records under a single lock, so readers see either
+// none or all of them, and returns their contiguous offsets. If appending
+// fails partway, the records appended so far are removed again.
+func (l *Log) AppendBatch(records []*api.Record) ([]uint64, error) {
+	defer l.recordSince(appendLatency, time.Now())
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	defer l.notifyAppended()
+
+	start, startOffset := len(l.segments)-1, l.activeSegment.nextOffset
+	offsets, err := l.appendBatch(records)
+	if err != nil {
+		if rollbackErr := l.rollback(start, startOffset); rollbackErr != nil {
+			return nil, errors.Join(err, rollbackErr)
+		}
+		return nil, err
+	}
+	return offsets, nil
+}
+
+func (l *Log) appendBatch(records []*api.Record) ([]uint64, error) {
+	offsets := make([]uint64, 0, len(records))
+	for _, record := range records {
+		record.Offset = l.activeSegment.nextOffset
+		off, err := l.append(record)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, off)
+	}
+	return offsets, l.commit(l.activeSegment, false)
+}
+
+// rollback removes the records from off on, which were appended to the
+// segment at index start and the segments created since. The segment becomes
+// the active one again.
+func (l *Log) rollback(start int, off uint64) error {
+	s := l.segments[start]
+	sealed := s != l.activeSegment
+	for _, created := range l.segments[start+1:] {
+		if err := l.cache.retire(created, created.Remove); err != nil {
+			return err
+		}
+	}
+	l.segments = l.segments[:start+1]
+	l.activeSegment = s
+	if sealed {
+		// reopened like the last segment of a log which is opened
+		l.segments = l.segments[:start]
+		if err := l.cache.retire(s, s.Close); err != nil {
+			return err
+		}
+		if err := l.newSegment(s.baseOffset); err != nil {
+			return err
+		}
+	}
+	return l.activeSegment.truncate(off)
+}
+
+// appendAt appends the record under the offset it already carries, e.g. when
+// a compacted log is restored from a snapshot.
+func (l *Log) appendAt(record *api.Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.notifyAppended()
+
+	off, err := l.append(record)
+	if err != nil {
+		return 0, err
+	}
+	return off, l.commit(l.activeSegment, false)
+}
 
-	off, err := l.activeSegment.Append(record)
+func (l *Log) append(record *api.Record) (uint64, error) {
+	off, err := l.activeSegment.appendAt(record)
 	if err != nil {
 		return 0, err
 	}
 
 	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(off + 1)
+		// the background sync only covers the active segment
+		if err = l.commit(l.activeSegment, true); err != nil {
+			return 0, err
+		}
+		// the maxed segment stays active until its successor exists
+		sealed := l.activeSegment
+		if err = l.newSegment(off + 1); err != nil {
+			return 0, err
+		}
+		err = sealed.seal()
 	}
 
 	return off, err
 }
 
-func (l *Log) Read(off uint64) (*api.Record, error) {
+func (l *Log) notifyAppended() {
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// Notify returns a channel which is closed right away if the log holds
+// records at or behind off, and otherwise by the next append. The channel is
+// shared by all callers waiting for the same append, so any number of readers
+// can tail the log without polling it. Woken callers have to check again
+// whether the records they wait for were appended.
+func (l *Log) Notify(off uint64) <-chan struct{} {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	if off < l.activeSegment.nextOffset {
+		return closed
+	}
+	return l.appended
+}
 
-	var s *segment
-	for _, segment := range l.segments {
-		if segment.baseOffset <= off && off < segment.nextOffset {
-			s = segment
-			break
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// commit makes the records appended to s durable as configured by
+// Config.Segment.Sync. sealed forces SyncGroup to sync s right away.
+func (l *Log) commit(s *segment, sealed bool) error {
+	switch l.Config.Segment.Sync {
+	case SyncAlways:
+		return s.store.Sync()
+	case SyncGroup:
+		syncBytes := l.Config.Segment.SyncBytes
+		if sealed || (syncBytes > 0 && s.store.unsynced() >= syncBytes) {
+			return s.store.Sync()
 		}
 	}
+	return s.store.Flush()
+}
 
-	if s == nil || s.nextOffset <= off {
+func (l *Log) syncEvery(interval time.Duration, done <-chan struct{}) {
+	logger := zap.L().Named("log")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.mu.RLock()
+			err := l.activeSegment.store.Sync()
+			l.mu.RUnlock()
+			if err != nil {
+				logger.Error("failed to sync log", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Read returns the record at off. If the record was compacted away, the next
+// available record is returned instead, so callers should continue reading
+// behind the offset of the returned record.
+func (l *Log) Read(off uint64) (*api.Record, error) {
+	defer l.recordSince(readLatency, time.Now())
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].baseOffset > off
+	}) - 1
+	if i < 0 || off < l.lowest {
 		return nil, api.ErrOffsetOutOfRange{Offset: off}
 	}
+
+	for _, s := range l.segments[i:] {
+		if off >= s.nextOffset {
+			continue
+		}
+		// the tail of the previous segment may have been compacted away
+		next := max(off, s.baseOffset)
+
+		record, err := l.readSegment(s, next)
+		if err == io.EOF {
+			continue
+		}
+		return record, err
+	}
+	return nil, api.ErrOffsetOutOfRange{Offset: off}
+}
+
+// ReadRange returns the records from start on, up to maxRecords of them and
+// maxBytes of their encoded size, along with the offset to read next. The
+// records are read from a single segment, and the first one is returned even
+// if it exceeds maxBytes. Zero limits are ignored.
+func (l *Log) ReadRange(start uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error) {
+	defer l.recordSince(readLatency, time.Now())
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].baseOffset > start
+	}) - 1
+	if i < 0 || start < l.lowest {
+		return nil, 0, api.ErrOffsetOutOfRange{Offset: start}
+	}
+
+	for _, s := range l.segments[i:] {
+		if start >= s.nextOffset {
+			continue
+		}
+		next := max(start, s.baseOffset)
+
+		if err := l.acquire(s); err != nil {
+			return nil, 0, err
+		}
+		records, err := s.readRange(next, maxRecords, maxBytes)
+		l.release(s)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		return records, records[len(records)-1].Offset + 1, nil
+	}
+	return nil, 0, api.ErrOffsetOutOfRange{Offset: start}
+}
+
+func (l *Log) readSegment(s *segment, off uint64) (*api.Record, error) {
+	if err := l.acquire(s); err != nil {
+		return nil, err
+	}
+	defer l.release(s)
 	return s.Read(off)
 }
 
+// acquire opens the files of s until release is called. The caller must hold
+// a lock of the log.
+func (l *Log) acquire(s *segment) error {
+	if s == l.activeSegment {
+		return nil
+	}
+	return l.cache.acquire(s)
+}
+
+func (l *Log) release(s *segment) {
+	if s != l.activeSegment {
+		l.cache.release(s)
+	}
+}
+
 func (l *Log) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if l.closeRetention != nil {
+		close(l.closeRetention)
+		l.closeRetention = nil
+	}
+	if l.closeCompaction != nil {
+		close(l.closeCompaction)
+		l.closeCompaction = nil
+	}
+	if l.closeSync != nil {
+		close(l.closeSync)
+		l.closeSync = nil
+	}
+	if l.Config.Segment.Sync != SyncOS {
+		if err := l.activeSegment.store.Sync(); err != nil {
+			return err
+		}
+	}
+
 	for _, segment := range l.segments {
-		err := segment.Close()
+		err := l.cache.retire(segment, segment.Close)
 		if err != nil {
 			return err
 		}
@@ -146,13 +522,41 @@ func (l *Log) Reset() error {
 		return err
 	}
 
-	return l.setup()
+	if err = os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	if err = l.setup(); err != nil {
+		return err
+	}
+
+	// Close stopped the background work along with the old segments
+	l.start()
+	return nil
+}
+
+// Segments describes the segments of the log.
+func (l *Log) Segments() []*api.Segment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	segments := make([]*api.Segment, 0, len(l.segments))
+	for _, s := range l.segments {
+		segments = append(segments, &api.Segment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			SizeBytes:  s.Size(),
+			Sealed:     s.sealed,
+			Open:       s == l.activeSegment || l.cache.isOpen(s),
+			ModTime:    s.modTime.UnixNano(),
+		})
+	}
+	return segments
 }
 
 func (l *Log) LowestOffset() (uint64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	return l.segments[0].baseOffset, nil
+	return max(l.segments[0].baseOffset, l.lowest), nil
 }
 
 func (l *Log) HighestOffset() (uint64, error) {
@@ -168,13 +572,58 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return off - 1, nil
 }
 
+// OffsetForTime returns the offset of the first record whose timestamp is at
+// or after timestamp, or the offset of the next record if there is none.
+func (l *Log) OffsetForTime(timestamp int64) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// the first segment starting at or after timestamp may already be too
+	// late, the record in question can be at the end of its predecessor
+	i := sort.Search(len(l.segments), func(i int) bool {
+		first, ok := l.segments[i].firstTimestamp()
+		return !ok || first >= timestamp
+	})
+	if i > 0 {
+		i--
+	}
+
+	for _, s := range l.segments[i:] {
+		if err := l.acquire(s); err != nil {
+			return 0, err
+		}
+		off, err := s.offsetForTime(timestamp)
+		l.release(s)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		// truncated records are skipped
+		return max(off, l.lowest), nil
+	}
+	return max(l.activeSegment.nextOffset, l.lowest), nil
+}
+
+// Truncate removes the records up to and including lowest. They become
+// unreadable right away, whereas their segments are only removed once all of
+// their records are truncated. So replicas whose segments are laid out
+// differently still keep the same records.
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if lowest+1 > l.lowest {
+		if err := writeLowest(l.Dir, lowest+1); err != nil {
+			return err
+		}
+		l.lowest = lowest + 1
+	}
+
 	var segments []*segment
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
-			if err := s.Remove(); err != nil {
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
+			if err := l.cache.retire(s, s.Remove); err != nil {
 				return err
 			}
 			continue
@@ -185,25 +634,135 @@ func (l *Log) Truncate(lowest uint64) error {
 	return nil
 }
 
-type originReader struct {
-	*store
-	off int64
+func hasRetention(c Config) bool {
+	return c.Retention.MaxAge > 0 || c.Retention.MaxBytes > 0
 }
 
-func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
-	o.off += int64(n)
-	return n, err
+// enforceRetention checks the retention limits of c every check interval
+// until done is closed. Records past the limits are removed by calling
+// truncate, which lets replicated logs drop the same records on every replica.
+func (l *Log) enforceRetention(c Config, truncate func(lowest uint64) error, done <-chan struct{}) {
+	interval := c.Retention.CheckInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	logger := zap.L().Named("log")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			lowest, ok := l.retentionOffset(c.Retention.MaxAge, c.Retention.MaxBytes, now)
+			if !ok {
+				continue
+			}
+			if err := truncate(lowest); err != nil {
+				logger.Error("failed to enforce retention", zap.Error(err), zap.Uint64("lowest", lowest))
+			}
+		}
+	}
 }
 
-func (l *Log) Reader() io.Reader {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// retentionOffset returns the highest offset of the sealed segments that are
+// past the retention limits. The active segment is never considered.
+func (l *Log) retentionOffset(maxAge time.Duration, maxBytes uint64, now time.Time) (uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-	readers := make([]io.Reader, len(l.segments))
-	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+	var size uint64
+	for _, s := range l.segments {
+		size += s.Size()
 	}
 
-	return io.MultiReader(readers...)
+	var lowest uint64
+	var found bool
+	for _, s := range l.segments[:len(l.segments)-1] {
+		expired := maxAge > 0 && now.Sub(s.modTime) > maxAge
+		exceeded := maxBytes > 0 && size > maxBytes
+		if !expired && !exceeded {
+			break
+		}
+		if s.nextOffset > s.baseOffset {
+			lowest, found = s.nextOffset-1, true
+		}
+		size -= s.Size()
+	}
+	return lowest, found
+}
+
+// storeReader reads sections of store files, which were opened when the
+// reader was created. Open files stay readable after their segment is sealed,
+// removed or replaced by a compaction.
+type storeReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *storeReader) Close() error {
+	var errs []error
+	for _, f := range r.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Reader returns the store files of the log concatenated, leaving out the
+// records which were truncated. It reads the records the log holds right now
+// and has to be closed.
+func (l *Log) Reader() (io.ReadCloser, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if err := l.activeSegment.store.Flush(); err != nil {
+		return nil, err
+	}
+
+	r := &storeReader{}
+	var readers []io.Reader
+	for _, segment := range l.segments {
+		from, to, err := l.truncated(segment)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		f, err := os.Open(segment.storePath)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, f)
+
+		// legacy stores have no header, so they get one. Otherwise they
+		// would be read in the format of the store in front of them, e.g.
+		// a legacy store which was compacted into the current format.
+		if segment.storeFormat() == storeVersionLegacy {
+			readers = append(readers, bytes.NewReader(storeHeader(storeVersionLegacy)))
+		}
+		// the header stays in front of the records which are kept
+		for _, section := range [][2]uint64{{0, from}, {to, segment.storeSize()}} {
+			off, size := int64(section[0]), int64(section[1]-section[0])
+			if size > 0 {
+				readers = append(readers, io.NewSectionReader(f, off, size))
+			}
+		}
+	}
+
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// truncated returns the store positions between which s holds truncated
+// records, which are equal if there are none.
+func (l *Log) truncated(s *segment) (from, to uint64, err error) {
+	if s.baseOffset >= l.lowest {
+		return 0, 0, nil
+	}
+	if err = l.acquire(s); err != nil {
+		return 0, 0, err
+	}
+	defer l.release(s)
+	return s.store.dataStart(), s.position(l.lowest), nil
 }
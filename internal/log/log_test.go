@@ -3,8 +3,12 @@ package log
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path"
+	"strconv"
 	"testing"
+	"time"
 
 	api "github.com/justagabriel/proglog/api/v1"
 	"github.com/justagabriel/proglog/internal"
@@ -19,6 +23,8 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"corrupt record":                    testCorruptRecord,
+		"append batch":                      testAppendBatch,
 	}
 
 	config := Config{}
@@ -55,6 +61,26 @@ func testAppendRead(t *testing.T, log *Log) {
 	}
 }
 
+func testAppendBatch(t *testing.T, log *Log) {
+	records := []*api.Record{
+		{Value: []byte("hello world0")},
+		{Value: []byte("hello world1")},
+		{Value: []byte("hello world2")},
+	}
+
+	// the batch spans several segments
+	offsets, err := log.AppendBatch(records)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2}, offsets)
+	require.Greater(t, len(log.segments), 1)
+
+	for i, off := range offsets {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, records[i].Value, read.Value)
+	}
+}
+
 func testOutOfRangeError(t *testing.T, log *Log) {
 	read, err := log.Read(1)
 	require.Nil(t, read)
@@ -103,12 +129,15 @@ func testReader(t *testing.T, log *Log) {
 	require.NoError(t, err)
 	require.Equal(t, off, uint64(0))
 
-	reader := log.Reader()
-	b, err := io.ReadAll(reader)
+	r, err := log.Reader()
+	require.NoError(t, err)
+	defer r.Close()
+	reader := newRecordReader(r)
+	b, err := reader.Next()
 	require.NoError(t, err)
 
 	read := &api.Record{}
-	err = proto.Unmarshal(b[lenWidth:], read)
+	err = proto.Unmarshal(b, read)
 	require.NoError(t, err)
 	require.Equal(t, append.Value, read.Value)
 }
@@ -129,3 +158,700 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+func testCorruptRecord(t *testing.T, log *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	_, err = log.Read(off)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(log.segments[0].storePath, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("!"), fi.Size()-1)
+	require.NoError(t, err)
+
+	read, err := log.Read(off)
+	require.Nil(t, read)
+	require.Equal(t, api.ErrCorruptRecord{Offset: off}, err)
+}
+
+func TestLogReadRange(t *testing.T) {
+	dir := internal.GetTempDir(t, "read-range-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 100
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 2)
+
+	// unlimited reads stop at the end of each segment
+	var next uint64
+	for _, s := range log.segments[:len(log.segments)-1] {
+		records, off, err := log.ReadRange(next, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, s.nextOffset, off)
+		require.Len(t, records, int(s.nextOffset-next))
+		for i, record := range records {
+			require.Equal(t, next+uint64(i), record.Offset)
+		}
+		next = off
+	}
+	_, _, err = log.ReadRange(n, 0, 0)
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: n}, err)
+
+	records, next, err := log.ReadRange(0, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(2), next)
+
+	// the first record is returned even if it exceeds the byte limit
+	records, next, err = log.ReadRange(0, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(1), next)
+}
+
+func TestLogTruncateRecords(t *testing.T) {
+	// arrange
+	// the replicas lay out the same records in different segments
+	var logs []*Log
+	for _, maxStoreBytes := range []uint64{32, 1024} {
+		dir := internal.GetTempDir(t, "truncate-test")
+		defer os.RemoveAll(dir)
+
+		c := Config{}
+		c.Segment.MaxStoreBytes = maxStoreBytes
+		log, err := NewLog(dir, c)
+		require.NoError(t, err)
+		defer log.Close()
+
+		for i := 0; i < 4; i++ {
+			_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+			require.NoError(t, err)
+		}
+		logs = append(logs, log)
+	}
+
+	for _, log := range logs {
+		// act
+		require.NoError(t, log.Truncate(1))
+
+		// assert
+		lowest, err := log.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), lowest)
+
+		_, err = log.Read(1)
+		require.Equal(t, api.ErrOffsetOutOfRange{Offset: 1}, err)
+		_, _, err = log.ReadRange(0, 0, 0)
+		require.Equal(t, api.ErrOffsetOutOfRange{Offset: 0}, err)
+		off, err := log.OffsetForTime(0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), off)
+
+		r, err := log.Reader()
+		require.NoError(t, err)
+		defer r.Close()
+		records := newRecordReader(r)
+		for want := uint64(2); want < 4; want++ {
+			b, err := records.Next()
+			require.NoError(t, err)
+			read := &api.Record{}
+			require.NoError(t, proto.Unmarshal(b, read))
+			require.Equal(t, want, read.Offset)
+		}
+		_, err = records.Next()
+		require.ErrorIs(t, err, io.EOF)
+
+		// the lowest offset is kept once the log is opened again
+		require.NoError(t, log.Close())
+		reopened, err := NewLog(log.Dir, log.Config)
+		require.NoError(t, err)
+		lowest, err = reopened.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), lowest)
+		require.NoError(t, reopened.Close())
+	}
+}
+
+func TestLogReaderKeepsFiles(t *testing.T) {
+	// arrange
+	dir := internal.GetTempDir(t, "reader-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// every other record fills a segment
+	c.Segment.MaxStoreBytes = 48
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 2)
+
+	r, err := log.Reader()
+	require.NoError(t, err)
+	defer r.Close()
+
+	// act
+	// the active segment is sealed and the first one removed
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, log.Truncate(1))
+	_, err = os.Stat(path.Join(dir, "0.store"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// assert
+	records := newRecordReader(r)
+	for want := uint64(0); want < 3; want++ {
+		b, err := records.Next()
+		require.NoError(t, err)
+		read := &api.Record{}
+		require.NoError(t, proto.Unmarshal(b, read))
+		require.Equal(t, want, read.Offset)
+	}
+	_, err = records.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestLogAppendBatchRollback(t *testing.T) {
+	// arrange
+	dir := internal.GetTempDir(t, "batch-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	_, err = log.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+
+	// every record fills a segment, the one for offset 3 can't be created
+	blocked := path.Join(dir, "3.store")
+	require.NoError(t, os.Mkdir(blocked, 0o755))
+
+	records := []*api.Record{
+		{Value: []byte("batch0")},
+		{Value: []byte("batch1")},
+		{Value: []byte("batch2")},
+	}
+
+	// act
+	_, err = log.AppendBatch(records)
+
+	// assert
+	require.Error(t, err)
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	_, err = log.Read(1)
+	require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+
+	require.NoError(t, os.Remove(blocked))
+	offsets, err := log.AppendBatch(records)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3}, offsets)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i, off := range offsets {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, records[i].Value, read.Value)
+	}
+	off, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func TestLogNotify(t *testing.T) {
+	dir := internal.GetTempDir(t, "notify-test")
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	waiting := []<-chan struct{}{log.Notify(0), log.Notify(0)}
+	later := log.Notify(1)
+	for _, c := range append(waiting, later) {
+		select {
+		case <-c:
+			t.Fatal("notified before the record was appended")
+		default:
+		}
+	}
+
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	for _, c := range waiting {
+		<-c
+	}
+	// readers of the next offset are woken up as well and wait again
+	<-later
+	select {
+	case <-log.Notify(1):
+		t.Fatal("notified for an offset which is not appended yet")
+	default:
+	}
+	<-log.Notify(0)
+}
+
+func TestLogRetention(t *testing.T) {
+	scenarios := map[string]func(c *Config){
+		"removes segments older than max age": func(c *Config) {
+			c.Retention.MaxAge = time.Nanosecond
+		},
+		"removes oldest segments exceeding max bytes": func(c *Config) {
+			c.Retention.MaxBytes = 1
+		},
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			dir := internal.GetTempDir(t, "retention-test")
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = 32
+			c.Retention.CheckInterval = 10 * time.Millisecond
+			fn(&c)
+
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			for i := 0; i < 3; i++ {
+				_, err := log.Append(&api.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+
+			// every record fills a segment, only the active one is kept
+			require.Eventually(t, func() bool {
+				off, err := log.LowestOffset()
+				return err == nil && off == 3
+			}, time.Second, 10*time.Millisecond)
+
+			_, err = log.Read(2)
+			require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+		})
+	}
+}
+
+func TestLogCompaction(t *testing.T) {
+	records := []*api.Record{
+		{Key: []byte("a"), Value: []byte("a1")},
+		{Key: []byte("b"), Value: []byte("b1")},
+		{Value: []byte("no key")},
+		{Key: []byte("a"), Value: []byte("a2")},
+		// tombstone
+		{Key: []byte("b")},
+		{Key: []byte("c"), Value: []byte("c1")},
+	}
+
+	scenarios := map[string]struct {
+		tombstoneRetention time.Duration
+		// want maps each offset to the offset of the record read for it
+		want map[uint64]uint64
+	}{
+		"keeps tombstones within their retention": {
+			tombstoneRetention: time.Hour,
+			want:               map[uint64]uint64{0: 2, 1: 2, 2: 2, 3: 3, 4: 4, 5: 5},
+		},
+		"removes expired tombstones": {
+			tombstoneRetention: 0,
+			want:               map[uint64]uint64{0: 2, 1: 2, 2: 2, 3: 3, 4: 5, 5: 5},
+		},
+	}
+
+	for scenario, tc := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			dir := internal.GetTempDir(t, "compaction-test")
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			// every record fills a segment, so all of them are sealed
+			c.Segment.MaxStoreBytes = 1
+			c.Compaction.TombstoneRetention = tc.tombstoneRetention
+
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for _, record := range records {
+				_, err := log.Append(record)
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, log.Compact())
+			requireCompacted(t, log, tc.want)
+
+			// the compacted segments are picked up when the log is reopened
+			require.NoError(t, log.Close())
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			requireCompacted(t, log, tc.want)
+
+			// new records continue behind the compacted ones
+			off, err := log.Append(&api.Record{Key: []byte("a"), Value: []byte("a3")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(records)), off)
+		})
+	}
+}
+
+func TestLogCompactionDoesNotBlockAppends(t *testing.T) {
+	// arrange
+	dir := internal.GetTempDir(t, "compaction-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for _, record := range []*api.Record{
+		{Key: []byte("a"), Value: []byte("a1")},
+		// tombstone
+		{Key: []byte("a")},
+		{Value: []byte("active")},
+	} {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	// act & assert
+	// the tombstone is checked while its segment is rewritten
+	appended := make(chan error)
+	err = log.compactBelow(math.MaxUint64, func(*segment, *api.Record) bool {
+		go func() {
+			_, err := log.Append(&api.Record{Value: []byte("during compaction")})
+			appended <- err
+		}()
+		select {
+		case err := <-appended:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("append waited for the compaction")
+		}
+		return true
+	})
+	require.NoError(t, err)
+	requireCompacted(t, log, map[uint64]uint64{0: 2, 1: 2})
+}
+
+func TestLogCompactionConcurrent(t *testing.T) {
+	// arrange
+	dir := internal.GetTempDir(t, "compaction-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+
+	// act
+	// appends, truncations and reads race the compactions
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for i := 1; i < 500; i++ {
+			off, err := log.Append(&api.Record{Key: []byte(strconv.Itoa(i % 10)), Value: []byte("value")})
+			if err == nil && i%100 == 99 {
+				err = log.Truncate(off - 50)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		require.NoError(t, log.Compact())
+		lowest, err := log.LowestOffset()
+		require.NoError(t, err)
+		_, err = log.Read(lowest)
+		require.NoError(t, err)
+	}
+
+	// assert
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	default:
+	}
+	require.NoError(t, log.Compact())
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	read, err := log.Read(highest)
+	require.NoError(t, err)
+	require.Equal(t, highest, read.Offset)
+}
+
+func TestLogFinishCompaction(t *testing.T) {
+	dir := internal.GetTempDir(t, "compaction-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for _, value := range []string{"a1", "a2"} {
+		_, err := log.Append(&api.Record{Key: []byte("a"), Value: []byte(value)})
+		require.NoError(t, err)
+	}
+
+	// simulate a crash after the compacted files of the first segment were
+	// written and while those of the second one are still written
+	latest := map[string]uint64{"a": 1}
+	keep := func(record *api.Record) bool {
+		return latest[string(record.Key)] == record.Offset
+	}
+	cleaned, err := log.cleanSegment(log.segments[0], keep)
+	require.NoError(t, err)
+	require.True(t, cleaned)
+	cleanedFiles := fileNames(segmentPaths(dir, 0, cleanedExt))
+	swap := fileNames(segmentPaths(dir, 0, swapExt))
+	for i := range cleanedFiles {
+		require.NoError(t, os.Rename(cleanedFiles[i], swap[i]))
+	}
+	unfinished, _, _ := segmentPaths(dir, 1, cleanedExt)
+	require.NoError(t, os.WriteFile(unfinished, []byte("partial"), 0644))
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset)
+	for _, name := range swap {
+		require.NoFileExists(t, name)
+	}
+	require.NoFileExists(t, unfinished)
+}
+
+func requireCompacted(t *testing.T, log *Log, want map[uint64]uint64) {
+	t.Helper()
+	for off, wantOff := range want {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, wantOff, read.Offset, "read offset %d", off)
+	}
+}
+
+func TestLogOffsetForTime(t *testing.T) {
+	dir := internal.GetTempDir(t, "time-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// every record fills a segment
+	c.Segment.MaxStoreBytes = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for _, timestamp := range []int64{10, 20, 30} {
+		_, err := log.Append(&api.Record{Value: []byte("hello world"), Timestamp: timestamp})
+		require.NoError(t, err)
+	}
+
+	// times past the newest record map to the offset of the next record
+	want := map[int64]uint64{0: 0, 10: 0, 15: 1, 20: 1, 25: 2, 30: 2, 31: 3}
+	for timestamp, wantOff := range want {
+		off, err := log.OffsetForTime(timestamp)
+		require.NoError(t, err)
+		require.Equal(t, wantOff, off, "timestamp %d", timestamp)
+	}
+}
+
+func TestLogDurability(t *testing.T) {
+	scenarios := map[string]struct {
+		configure func(c *Config)
+		// check verifies whether the appended records were fsynced
+		check func(t *testing.T, s *store)
+	}{
+		"always syncs every append": {
+			configure: func(c *Config) {
+				c.Segment.Sync = SyncAlways
+			},
+			check: requireSynced,
+		},
+		"group syncs once enough bytes are pending": {
+			configure: func(c *Config) {
+				c.Segment.Sync = SyncGroup
+				c.Segment.SyncBytes = 1
+			},
+			check: requireSynced,
+		},
+		"group syncs on an interval": {
+			configure: func(c *Config) {
+				c.Segment.Sync = SyncGroup
+				c.Segment.SyncInterval = 10 * time.Millisecond
+			},
+			check: func(t *testing.T, s *store) {
+				require.Eventually(t, func() bool {
+					return s.unsynced() == 0
+				}, time.Second, 10*time.Millisecond)
+			},
+		},
+		"os leaves syncing to the operating system": {
+			configure: func(c *Config) {
+				c.Segment.Sync = SyncOS
+			},
+			check: func(t *testing.T, s *store) {
+				require.NotZero(t, s.unsynced())
+			},
+		},
+	}
+
+	for scenario, tc := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			dir := internal.GetTempDir(t, "durability-test")
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1024
+			tc.configure(&c)
+
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			_, err = log.Append(&api.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+			_, err = log.AppendBatch([]*api.Record{
+				{Value: []byte("hello world")},
+				{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+			tc.check(t, log.activeSegment.store)
+
+			// simulate a crash by opening the files again without closing them
+			crashed, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer crashed.Close()
+			for off := uint64(0); off < 3; off++ {
+				read, err := crashed.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, []byte("hello world"), read.Value)
+			}
+		})
+	}
+}
+
+func TestLogResetKeepsSyncing(t *testing.T) {
+	// arrange
+	dir := internal.GetTempDir(t, "durability-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.Sync = SyncGroup
+	c.Segment.SyncInterval = 10 * time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// act
+	// e.g. when a replica restores a snapshot
+	require.NoError(t, log.Reset())
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// assert
+	require.Eventually(t, func() bool {
+		log.mu.RLock()
+		defer log.mu.RUnlock()
+		return log.activeSegment.store.unsynced() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func requireSynced(t *testing.T, s *store) {
+	t.Helper()
+	require.Zero(t, s.unsynced())
+}
+
+func TestLogUnknownSyncMode(t *testing.T) {
+	dir := internal.GetTempDir(t, "durability-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.Sync = "sometimes"
+	_, err := NewLog(dir, c)
+	require.Error(t, err)
+}
+
+func TestLogOpenSegments(t *testing.T) {
+	dir := internal.GetTempDir(t, "open-segments-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), c.Segment.MaxOpenSegments+1)
+
+	requireRecords := func(log *Log) {
+		for i := n - 1; i >= 0; i-- {
+			record, err := log.Read(uint64(i))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("record %d", i)), record.Value)
+			require.LessOrEqual(t, log.cache.open(), c.Segment.MaxOpenSegments)
+		}
+	}
+	requireRecords(log)
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for _, s := range log.segments[:len(log.segments)-1] {
+		// sealed segments are only opened once they are read
+		require.Nil(t, s.store)
+	}
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(n-1), highest)
+	requireRecords(log)
+
+	_, err = log.Read(n)
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: n}, err)
+}
@@ -0,0 +1,42 @@
+package log
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	appendLatency = stats.Float64("proglog/log/append_latency", "Latency of appends to the local log", stats.UnitMilliseconds)
+	readLatency   = stats.Float64("proglog/log/read_latency", "Latency of reads from the local log", stats.UnitMilliseconds)
+)
+
+var latencyDistribution = view.Distribution(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000)
+
+// Views aggregate the append and read latencies of all logs into histograms,
+// leaving out the log raft keeps its entries in.
+// They are recorded once the views are registered.
+var Views = []*view.View{
+	{
+		Name:        appendLatency.Name(),
+		Description: "Distribution of the append latencies of the log",
+		Measure:     appendLatency,
+		Aggregation: latencyDistribution,
+	},
+	{
+		Name:        readLatency.Name(),
+		Description: "Distribution of the read latencies of the log",
+		Measure:     readLatency,
+		Aggregation: latencyDistribution,
+	},
+}
+
+// recordSince records the milliseconds since start to m if the log is timed.
+func (l *Log) recordSince(m *stats.Float64Measure, start time.Time) {
+	if !l.timed {
+		return
+	}
+	stats.Record(context.Background(), m.M(float64(time.Since(start))/float64(time.Millisecond)))
+}
@@ -1,11 +1,16 @@
 package log
 
 import (
+	"container/list"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"time"
 
 	api "github.com/justagabriel/proglog/api/v1"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -14,17 +19,58 @@ type segment struct {
 	index                  *index
 	baseOffset, nextOffset uint64
 	config                 Config
+	// modTime is the time the newest record was appended
+	modTime time.Time
+
+	timeIndex *timeIndex
+	// maxTimestamp is the highest timestamp of the segment's records and
+	// timeIndexedPos the store position of the last time index entry
+	maxTimestamp   int64
+	timeIndexedPos uint64
+
+	storePath, indexPath, timeIndexPath string
+	// sealed segments are no longer appended to and only have their files
+	// open while in use, so they keep what's needed of them while closed
+	sealed     bool
+	size       uint64
+	storeBytes uint64
+	version    uint32
+	firstTime  timeEntry
+	hasTime    bool
+	// refs and elem track the use of sealed segments by the segment cache.
+	// Retired segments are no longer part of the log, and the files of the
+	// ones still in use are closed by their last release.
+	refs    int
+	elem    *list.Element
+	retired bool
+	closer  func() error
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	storePath, indexPath, timeIndexPath := segmentPaths(dir, baseOffset, "")
+	return openSegment(storePath, indexPath, timeIndexPath, baseOffset, c)
+}
+
+// segmentPaths returns the paths of the files of a segment, with ext appended
+// to their names.
+func segmentPaths(dir string, baseOffset uint64, ext string) (storePath, indexPath, timeIndexPath string) {
+	return path.Join(dir, fmt.Sprintf("%d%s%s", baseOffset, ".store", ext)),
+		path.Join(dir, fmt.Sprintf("%d%s%s", baseOffset, ".index", ext)),
+		path.Join(dir, fmt.Sprintf("%d%s%s", baseOffset, ".timeindex", ext))
+}
+
+func openSegment(storePath, indexPath, timeIndexPath string, baseOffset uint64, c Config) (*segment, error) {
 	s := &segment{
-		baseOffset: baseOffset,
-		config:     c,
+		baseOffset:    baseOffset,
+		config:        c,
+		storePath:     storePath,
+		indexPath:     indexPath,
+		timeIndexPath: timeIndexPath,
 	}
 
 	var err error
 	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
+		storePath,
 		os.O_RDWR|os.O_CREATE|os.O_APPEND,
 		0644,
 	)
@@ -37,8 +83,14 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
+	fi, err := storeFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s.modTime = fi.ModTime()
+
 	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
+		indexPath,
 		os.O_RDWR|os.O_CREATE,
 		0644,
 	)
@@ -51,6 +103,11 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
+	err = s.recover()
+	if err != nil {
+		return nil, err
+	}
+
 	off, _, err := s.index.Read(-1)
 	if err != nil {
 		s.nextOffset = baseOffset
@@ -58,12 +115,339 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		s.nextOffset = baseOffset + uint64(off) + 1
 	}
 
+	_, err = os.Stat(timeIndexPath)
+	rebuild := errors.Is(err, os.ErrNotExist)
+	timeIndexFile, err := os.OpenFile(
+		timeIndexPath,
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.timeIndex, err = newTimeIndex(timeIndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recoverTimeIndex(rebuild)
+	if err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
+// loadSegment registers a sealed segment without opening its files. Segments
+// whose files don't look like they were closed cleanly are opened once, which
+// recovers them.
+func loadSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	storePath, indexPath, timeIndexPath := segmentPaths(dir, baseOffset, "")
+	s := &segment{
+		baseOffset:    baseOffset,
+		config:        c,
+		storePath:     storePath,
+		indexPath:     indexPath,
+		timeIndexPath: timeIndexPath,
+		sealed:        true,
+	}
+
+	clean, err := s.loadMeta()
+	if err != nil {
+		return nil, err
+	}
+	if clean {
+		return s, nil
+	}
+
+	s, err = openSegment(storePath, indexPath, timeIndexPath, baseOffset, c)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.seal()
+}
+
+// loadMeta reads what is kept of sealed segments from their files. It reports
+// whether the files are consistent, i.e. the last index entry points to the
+// last record of the store.
+func (s *segment) loadMeta() (bool, error) {
+	var sizes [3]uint64
+	for i, name := range []string{s.storePath, s.indexPath, s.timeIndexPath} {
+		fi, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		sizes[i] = uint64(fi.Size())
+		if i == 0 {
+			s.modTime = fi.ModTime()
+		}
+	}
+	storeSize, indexSize, timeIndexSize := sizes[0], sizes[1], sizes[2]
+	s.size = storeSize + indexSize + timeIndexSize
+	s.storeBytes = storeSize
+	if indexSize%entWidth != 0 || timeIndexSize%timeEntWidth != 0 {
+		return false, nil
+	}
+
+	storeFile, err := os.Open(s.storePath)
+	if err != nil {
+		return false, err
+	}
+	defer storeFile.Close()
+
+	dataStart, frame := uint64(0), frameWidth(storeVersionLegacy)
+	if storeSize >= headerWidth {
+		header := make([]byte, headerWidth)
+		if _, err = storeFile.ReadAt(header, 0); err != nil {
+			return false, err
+		}
+		if version, ok := parseHeader(header); ok {
+			dataStart, frame = headerWidth, frameWidth(version)
+			s.version = version
+		}
+	}
+
+	if indexSize == 0 {
+		s.nextOffset = s.baseOffset
+		return storeSize == dataStart, nil
+	}
+
+	entry, err := readFileAt(s.indexPath, indexSize-entWidth, entWidth)
+	if err != nil {
+		return false, err
+	}
+	off := enc.Uint32(entry[:offWidth])
+	pos := enc.Uint64(entry[offWidth:])
+	if pos < dataStart || (pos == 0 && indexSize > entWidth) || pos+frame > storeSize {
+		return false, nil
+	}
+	length := make([]byte, lenWidth)
+	if _, err = storeFile.ReadAt(length, int64(pos)); err != nil {
+		return false, err
+	}
+	if pos+frame+enc.Uint64(length) != storeSize {
+		return false, nil
+	}
+	s.nextOffset = s.baseOffset + uint64(off) + 1
+
+	if timeIndexSize > 0 {
+		entry, err = readFileAt(s.timeIndexPath, 0, timeEntWidth)
+		if err != nil {
+			return false, err
+		}
+		s.firstTime = timeEntry{
+			timestamp: int64(enc.Uint64(entry[:tsWidth])),
+			off:       enc.Uint32(entry[tsWidth:]),
+		}
+		s.hasTime = true
+	}
+	return true, nil
+}
+
+func readFileAt(name string, off, n uint64) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, n)
+	_, err = f.ReadAt(b, int64(off))
+	return b, err
+}
+
+// open opens the files of a sealed segment, mapping its index read-only.
+func (s *segment) open() (err error) {
+	var closers []io.Closer
+	defer func() {
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+		}
+	}()
+
+	storeFile, err := os.OpenFile(s.storePath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	closers = append(closers, storeFile)
+	store, err := newStore(storeFile)
+	if err != nil {
+		return err
+	}
+
+	indexFile, err := os.Open(s.indexPath)
+	if err != nil {
+		return err
+	}
+	closers = append(closers, indexFile)
+	index, err := newSealedIndex(indexFile)
+	if err != nil {
+		return err
+	}
+
+	timeIndexFile, err := os.OpenFile(s.timeIndexPath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	closers = append(closers, timeIndexFile)
+	timeIndex, err := newTimeIndex(timeIndexFile)
+	if err != nil {
+		return err
+	}
+
+	s.store, s.index, s.timeIndex = store, index, timeIndex
+	return nil
+}
+
+// seal closes the files of a segment which is no longer appended to. They
+// are opened again by open when the segment is read.
+func (s *segment) seal() error {
+	s.cacheMeta()
+	s.sealed = true
+	return s.Close()
+}
+
+// cacheMeta keeps what's needed of an open segment once its files are closed.
+func (s *segment) cacheMeta() {
+	s.size = s.openSize()
+	s.storeBytes = s.store.size
+	s.version = s.store.version
+	s.firstTime, s.hasTime = s.timeIndex.first()
+}
+
+// recoverTimeIndex drops the time index entries of records which recover cut
+// off, and rebuilds the time index from the records if its file was missing.
+func (s *segment) recoverTimeIndex(rebuild bool) error {
+	err := s.timeIndex.truncate(uint32(s.nextOffset - s.baseOffset))
+	if err != nil {
+		return err
+	}
+
+	if rebuild {
+		return s.forEach(s.indexTime)
+	}
+
+	last, ok := s.timeIndex.last()
+	if !ok {
+		return nil
+	}
+	_, pos, err := s.index.Find(last.off)
+	if err != nil {
+		return err
+	}
+	s.maxTimestamp, s.timeIndexedPos = last.timestamp, pos
+	return nil
+}
+
+// indexTime adds the record to the time index if it raises the highest
+// timestamp of the segment and the last entry is at least timeIndexInterval
+// store bytes behind.
+func (s *segment) indexTime(record *api.Record, pos uint64) error {
+	_, indexed := s.timeIndex.last()
+	if indexed && record.Timestamp <= s.maxTimestamp {
+		return nil
+	}
+	s.maxTimestamp = record.Timestamp
+	if indexed && pos < s.timeIndexedPos+timeIndexInterval {
+		return nil
+	}
+	s.timeIndexedPos = pos
+	return s.timeIndex.Write(record.Timestamp, uint32(record.Offset-s.baseOffset))
+}
+
+// recover brings store and index back in sync after an unclean shutdown.
+// Index entries which are zeroed or point to incomplete records are dropped,
+// complete records missing from the index are indexed and everything behind
+// the last complete record is cut off the store.
+func (s *segment) recover() error {
+	fileSize := s.index.size
+	storeSize := s.store.size
+
+	s.index.scan(s.store.dataStart())
+	zeroed := (fileSize - s.index.size) / entWidth
+
+	var dropped uint64
+	end := s.store.dataStart()
+	for s.index.size > 0 {
+		_, pos, err := s.index.Read(-1)
+		if err != nil {
+			return err
+		}
+		p, err := s.store.Read(pos)
+		if err == nil {
+			end = pos + frameWidth(s.store.version) + uint64(len(p))
+			break
+		}
+		if !isIncomplete(err) {
+			return err
+		}
+		s.index.size -= entWidth
+		dropped++
+	}
+
+	var reindexed uint64
+	for end < s.store.size {
+		p, err := s.store.Read(end)
+		if isIncomplete(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		record := &api.Record{}
+		if err = proto.Unmarshal(p, record); err != nil || record.Offset < s.baseOffset {
+			break
+		}
+		if err = s.index.Write(uint32(record.Offset-s.baseOffset), end); err != nil {
+			break
+		}
+		end += frameWidth(s.store.version) + uint64(len(p))
+		reindexed++
+	}
+
+	if end < s.store.size {
+		if err := s.store.truncate(end); err != nil {
+			return err
+		}
+	}
+
+	if zeroed > 0 || dropped > 0 || reindexed > 0 || s.store.size < storeSize {
+		zap.L().Named("segment").Warn(
+			"recovered segment after unclean shutdown",
+			zap.String("store", s.store.Name()),
+			zap.Uint64("zeroed_index_entries", zeroed),
+			zap.Uint64("dropped_index_entries", dropped),
+			zap.Uint64("reindexed_records", reindexed),
+			zap.Uint64("truncated_store_bytes", storeSize-s.store.size),
+		)
+	}
+	return nil
+}
+
+func isIncomplete(err error) bool {
+	return errors.Is(err, errCorruptRecord) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
-	currentOffset := s.nextOffset
-	record.Offset = currentOffset
+	record.Offset = s.nextOffset
+	return s.appendAt(record)
+}
+
+// appendAt appends the record under the offset it already carries, which
+// leaves a gap in front of it if the offset is past the segment's next offset.
+func (s *segment) appendAt(record *api.Record) (offset uint64, err error) {
+	if record.Offset < s.nextOffset {
+		return 0, fmt.Errorf("offset %d is below the next offset %d", record.Offset, s.nextOffset)
+	}
+	currentOffset := record.Offset
 
 	p, err := proto.Marshal(record)
 	if err != nil {
@@ -75,30 +459,170 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	}
 	if err = s.index.Write(
 		// index offsets are relative to base offset
-		uint32(s.nextOffset-uint64(s.baseOffset)),
+		uint32(currentOffset-uint64(s.baseOffset)),
 		pos,
 	); err != nil {
 		return 0, err
 	}
+	if err = s.indexTime(record, pos); err != nil {
+		return 0, err
+	}
 
-	s.nextOffset++
+	s.nextOffset = currentOffset + 1
+	s.modTime = time.Now()
 	return currentOffset, nil
 }
 
+// truncate cuts off the records at or behind off, e.g. the ones of a batch
+// which failed partway.
+func (s *segment) truncate(off uint64) error {
+	if pos, ok := s.index.truncate(uint32(off - s.baseOffset)); ok {
+		if err := s.store.truncate(pos); err != nil {
+			return err
+		}
+	}
+	s.nextOffset = off
+	return s.recoverTimeIndex(false)
+}
+
+// Read returns the record at off. If the record was compacted away, the next
+// record of the segment is returned instead and io.EOF if there is none.
 func (s *segment) Read(off uint64) (*api.Record, error) {
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	relOff, pos, err := s.index.Find(uint32(off - s.baseOffset))
 	if err != nil {
 		return nil, err
 	}
+	off = s.baseOffset + uint64(relOff)
 
 	p, err := s.store.Read(pos)
+	if errors.Is(err, errCorruptRecord) {
+		return nil, api.ErrCorruptRecord{Offset: off}
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	record := &api.Record{}
 	err = proto.Unmarshal(p, record)
-	return record, err
+	if err != nil {
+		return nil, api.ErrCorruptRecord{Offset: off}
+	}
+	return record, nil
+}
+
+// position returns the store position of the segment's first record at or
+// after off, or the end of the store if there is none.
+func (s *segment) position(off uint64) uint64 {
+	if off >= s.nextOffset {
+		return s.store.size
+	}
+	_, pos, err := s.index.Find(uint32(max(off, s.baseOffset) - s.baseOffset))
+	if err != nil {
+		return s.store.size
+	}
+	return pos
+}
+
+// readRange returns the records of the segment from off on, within the limits
+// of Log.ReadRange.
+func (s *segment) readRange(off uint64, maxRecords int, maxBytes uint64) ([]*api.Record, error) {
+	var records []*api.Record
+	var size uint64
+	for off < s.nextOffset && (maxRecords == 0 || len(records) < maxRecords) {
+		record, err := s.Read(off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		size += uint64(proto.Size(record))
+		if maxBytes > 0 && size > maxBytes && len(records) > 0 {
+			break
+		}
+		records = append(records, record)
+		off = record.Offset + 1
+	}
+	return records, nil
+}
+
+// forEach calls fn with every record of the segment and its store position
+// in offset order.
+func (s *segment) forEach(fn func(record *api.Record, pos uint64) error) error {
+	for entry := int64(0); uint64(entry)*entWidth < s.index.size; entry++ {
+		relOff, pos, err := s.index.Read(entry)
+		if err != nil {
+			return err
+		}
+		record, err := s.Read(s.baseOffset + uint64(relOff))
+		if err != nil {
+			return err
+		}
+		if err = fn(record, pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offsetForTime returns the offset of the segment's first record at or after
+// timestamp and io.EOF if there is none.
+func (s *segment) offsetForTime(timestamp int64) (uint64, error) {
+	off := s.baseOffset
+	if relOff, ok := s.timeIndex.Lookup(timestamp); ok {
+		off += uint64(relOff)
+	}
+
+	for off < s.nextOffset {
+		record, err := s.Read(off)
+		if err != nil {
+			return 0, err
+		}
+		if record.Timestamp >= timestamp {
+			return record.Offset, nil
+		}
+		off = record.Offset + 1
+	}
+	return 0, io.EOF
+}
+
+// Size returns the amount of bytes the segment takes up on disk.
+func (s *segment) Size() uint64 {
+	// the files of sealed segments are opened and closed concurrently by
+	// readers, so only their cached values may be used
+	if s.sealed || s.store == nil {
+		return s.size
+	}
+	return s.openSize()
+}
+
+// storeSize returns the size of the segment's store file.
+func (s *segment) storeSize() uint64 {
+	if s.sealed || s.store == nil {
+		return s.storeBytes
+	}
+	return s.store.size
+}
+
+// storeFormat returns the format version of the segment's store file.
+func (s *segment) storeFormat() uint32 {
+	if s.sealed || s.store == nil {
+		return s.version
+	}
+	return s.store.version
+}
+
+func (s *segment) openSize() uint64 {
+	return s.store.size + s.index.size + uint64(len(s.timeIndex.entries))*timeEntWidth
+}
+
+// firstTimestamp returns the timestamp of the segment's first record.
+func (s *segment) firstTimestamp() (int64, bool) {
+	if s.sealed || s.store == nil {
+		return s.firstTime.timestamp, s.hasTime
+	}
+	first, ok := s.timeIndex.first()
+	return first.timestamp, ok
 }
 
 func (s *segment) IsMaxed() bool {
@@ -107,6 +631,13 @@ func (s *segment) IsMaxed() bool {
 }
 
 func (s *segment) Close() error {
+	if s.store == nil {
+		return nil
+	}
+	if !s.sealed {
+		s.cacheMeta()
+	}
+
 	err := s.index.Close()
 	if err != nil {
 		return err
@@ -117,7 +648,13 @@ func (s *segment) Close() error {
 		return err
 	}
 
-	return err
+	err = s.timeIndex.Close()
+	if err != nil {
+		return err
+	}
+
+	s.store, s.index, s.timeIndex = nil, nil, nil
+	return nil
 }
 
 func (s *segment) Remove() error {
@@ -126,11 +663,15 @@ func (s *segment) Remove() error {
 		return err
 	}
 
-	err = os.Remove(s.index.Name())
+	err = os.Remove(s.indexPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.storePath)
 	if err != nil {
 		return err
 	}
 
-	err = os.Remove(s.store.Name())
-	return err
+	return os.Remove(s.timeIndexPath)
 }
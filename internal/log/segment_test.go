@@ -59,3 +59,135 @@ func TestSegment(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, s.IsMaxed())
 }
+
+func TestSegmentRecover(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, dir string, s *segment, c Config){
+		"zeroed index entries are dropped":              testRecoverZeroedIndex,
+		"index entries of lost records are dropped":     testRecoverLostRecords,
+		"torn store tail is truncated":                  testRecoverTornStore,
+		"complete records missing in index are indexed": testRecoverUnindexedRecords,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			dir := internal.GetTempDir(t, "segment-recover-test")
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1024
+			c.Segment.MaxIndexBytes = 1024
+
+			s, err := newSegment(dir, 16, c)
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				_, err = s.Append(&api.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+
+			fn(t, dir, s, c)
+		})
+	}
+}
+
+// requireRecovered reopens the segment without closing it first, as if the
+// process was killed, and checks that it holds the wanted records. The size
+// of the recovered store is returned.
+func requireRecovered(t *testing.T, dir string, c Config, want uint64) uint64 {
+	t.Helper()
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	size := s.store.size
+	require.Equal(t, 16+want, s.nextOffset)
+	require.Equal(t, want*entWidth, s.index.size)
+
+	for off := uint64(16); off < s.nextOffset; off++ {
+		got, err := s.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, got.Offset)
+	}
+
+	off, err := s.Append(&api.Record{Value: []byte("hello again")})
+	require.NoError(t, err)
+	require.Equal(t, 16+want, off)
+	got, err := s.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello again"), got.Value)
+	return size
+}
+
+func testRecoverZeroedIndex(t *testing.T, dir string, s *segment, c Config) {
+	// flush the store, the index file stays at its full size
+	_, err := s.Read(16)
+	require.NoError(t, err)
+
+	requireRecovered(t, dir, c, 3)
+}
+
+func testRecoverLostRecords(t *testing.T, dir string, s *segment, c Config) {
+	// the records are still buffered, so only the index made it to disk
+	requireRecovered(t, dir, c, 0)
+}
+
+func testRecoverTornStore(t *testing.T, dir string, s *segment, c Config) {
+	require.NoError(t, s.Close())
+	size := s.storeSize()
+
+	f, err := os.OpenFile(s.storePath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.Equal(t, size, requireRecovered(t, dir, c, 3))
+}
+
+func testRecoverUnindexedRecords(t *testing.T, dir string, s *segment, c Config) {
+	require.NoError(t, s.Close())
+	require.NoError(t, os.Truncate(s.indexPath, int64(entWidth)))
+
+	requireRecovered(t, dir, c, 3)
+}
+
+func TestSegmentOffsetForTime(t *testing.T) {
+	dir := internal.GetTempDir(t, "segment-time-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Segment.MaxIndexBytes = 1024
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+
+	// records larger than the time index interval get an entry each, as long
+	// as they raise the highest timestamp
+	value := make([]byte, timeIndexInterval)
+	for _, timestamp := range []int64{10, 20, 20, 15, 30} {
+		_, err := s.Append(&api.Record{Value: value, Timestamp: timestamp})
+		require.NoError(t, err)
+	}
+	require.Len(t, s.timeIndex.entries, 3)
+
+	requireOffsetsForTime := func(s *segment) {
+		t.Helper()
+		want := map[int64]uint64{5: 16, 10: 16, 11: 17, 16: 17, 20: 17, 21: 20, 30: 20}
+		for timestamp, wantOff := range want {
+			off, err := s.offsetForTime(timestamp)
+			require.NoError(t, err)
+			require.Equal(t, wantOff, off, "timestamp %d", timestamp)
+		}
+		_, err := s.offsetForTime(31)
+		require.Equal(t, io.EOF, err)
+	}
+	requireOffsetsForTime(s)
+
+	// a missing time index is rebuilt from the records
+	require.NoError(t, s.Close())
+	require.NoError(t, os.Remove(s.timeIndexPath))
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Len(t, s.timeIndex.entries, 3)
+	requireOffsetsForTime(s)
+}
@@ -2,24 +2,52 @@ package log
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
 )
 
 var (
 	enc = binary.BigEndian
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// storeMagic prefixes every versioned store file. Legacy store files
+	// start directly with a record length, whose first byte is always zero,
+	// so both formats can be told apart by looking at the first bytes.
+	storeMagic = []byte("PLOG")
+
+	errCorruptRecord = errors.New("corrupt record")
+)
+
+const (
+	lenWidth    = 8
+	crcWidth    = 4
+	headerWidth = 8
 )
 
 const (
-	lenWidth = 8
+	// storeVersionLegacy frames records as: length | payload
+	storeVersionLegacy uint32 = 0
+	// storeVersionCRC frames records as: length | crc32c(payload) | payload
+	storeVersionCRC uint32 = 1
+
+	storeVersion = storeVersionCRC
 )
 
 type store struct {
 	*os.File
-	mu   sync.Mutex
-	buf  *bufio.Writer
-	size uint64
+	mu      sync.Mutex
+	buf     *bufio.Writer
+	size    uint64
+	version uint32
+	// synced is the size of the store at the last Sync
+	synced uint64
 }
 
 func newStore(f *os.File) (*store, error) {
@@ -28,12 +56,79 @@ func newStore(f *os.File) (*store, error) {
 		return nil, err
 	}
 
-	size := uint64(fi.Size())
-	return &store{
-		File: f,
-		size: size,
-		buf:  bufio.NewWriter(f),
-	}, nil
+	s := &store{
+		File:   f,
+		size:   uint64(fi.Size()),
+		synced: uint64(fi.Size()),
+		buf:    bufio.NewWriter(f),
+	}
+
+	err = s.setupHeader()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setupHeader writes the format header to new store files and detects the
+// format version of existing ones.
+func (s *store) setupHeader() error {
+	if s.size == 0 {
+		if _, err := s.File.Write(storeHeader(storeVersion)); err != nil {
+			return err
+		}
+		s.size = headerWidth
+		s.version = storeVersion
+		return nil
+	}
+
+	if s.size < headerWidth {
+		// too small to hold a record of either format, so this is a torn
+		// header of a store file that was just created
+		if err := s.File.Truncate(0); err != nil {
+			return err
+		}
+		s.size = 0
+		return s.setupHeader()
+	}
+
+	header := make([]byte, headerWidth)
+	if _, err := s.File.ReadAt(header, 0); err != nil {
+		return err
+	}
+	version, ok := parseHeader(header)
+	if !ok {
+		s.version = storeVersionLegacy
+		return nil
+	}
+	if version > storeVersion {
+		return fmt.Errorf("unsupported store format version %d: %q", version, s.File.Name())
+	}
+	s.version = version
+	return nil
+}
+
+// storeHeader returns the header of store files of the format version.
+func storeHeader(version uint32) []byte {
+	header := make([]byte, headerWidth)
+	copy(header, storeMagic)
+	enc.PutUint32(header[len(storeMagic):], version)
+	return header
+}
+
+func parseHeader(b []byte) (version uint32, ok bool) {
+	if !bytes.Equal(b[:len(storeMagic)], storeMagic) {
+		return 0, false
+	}
+	return enc.Uint32(b[len(storeMagic):headerWidth]), true
+}
+
+// frameWidth returns the amount of bytes written in front of each record.
+func frameWidth(version uint32) uint64 {
+	if version >= storeVersionCRC {
+		return lenWidth + crcWidth
+	}
+	return lenWidth
 }
 
 func (s *store) Append(p []byte) (u uint64, pos uint64, err error) {
@@ -46,14 +141,21 @@ func (s *store) Append(p []byte) (u uint64, pos uint64, err error) {
 		return 0, 0, err
 	}
 
+	if s.version >= storeVersionCRC {
+		err = binary.Write(s.buf, enc, crc32.Checksum(p, crcTable))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
 	w, err := s.buf.Write(p)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	w += lenWidth
-	s.size += uint64(w)
-	return uint64(w), pos, nil
+	n := uint64(w) + frameWidth(s.version)
+	s.size += n
+	return n, pos, nil
 }
 
 func (s *store) Read(pos uint64) ([]byte, error) {
@@ -62,17 +164,83 @@ func (s *store) Read(pos uint64) ([]byte, error) {
 	if err := s.buf.Flush(); err != nil {
 		return nil, err
 	}
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+	frame := make([]byte, frameWidth(s.version))
+	if pos+uint64(len(frame)) > s.size {
+		return nil, io.EOF
+	}
+	if _, err := s.File.ReadAt(frame, int64(pos)); err != nil {
 		return nil, err
 	}
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	size := enc.Uint64(frame[:lenWidth])
+	if size > s.size-pos-uint64(len(frame)) {
+		return nil, errCorruptRecord
+	}
+	b := make([]byte, size)
+	if _, err := s.File.ReadAt(b, int64(pos)+int64(len(frame))); err != nil {
 		return nil, err
 	}
+	if s.version >= storeVersionCRC && crc32.Checksum(b, crcTable) != enc.Uint32(frame[lenWidth:]) {
+		return nil, errCorruptRecord
+	}
 	return b, nil
 }
 
+// dataStart returns the position of the first record.
+func (s *store) dataStart() uint64 {
+	if s.version == storeVersionLegacy {
+		return 0
+	}
+	return headerWidth
+}
+
+// truncate cuts off all data behind the given position.
+func (s *store) truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+	if s.synced > size {
+		s.synced = size
+	}
+	return nil
+}
+
+// Flush hands buffered records to the operating system.
+func (s *store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Flush()
+}
+
+// Sync flushes buffered records and commits the file to stable storage.
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced == s.size {
+		return nil
+	}
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+	s.synced = s.size
+	return nil
+}
+
+// unsynced returns the amount of bytes appended since the last Sync.
+func (s *store) unsynced() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size - s.synced
+}
+
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -92,3 +260,49 @@ func (s *store) Close() error {
 	}
 	return s.File.Close()
 }
+
+// recordReader decodes the records of one or more concatenated store files,
+// e.g. the stream returned by Log.Reader.
+type recordReader struct {
+	r       io.Reader
+	version uint32
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{r: r, version: storeVersionLegacy}
+}
+
+// Next returns the payload of the next record or io.EOF once the stream is
+// exhausted.
+func (rr *recordReader) Next() ([]byte, error) {
+	b := make([]byte, lenWidth)
+	for {
+		if _, err := io.ReadFull(rr.r, b); err != nil {
+			return nil, err
+		}
+
+		// each store file of the stream starts with its own header
+		version, ok := parseHeader(b)
+		if !ok {
+			break
+		}
+		rr.version = version
+	}
+
+	size := enc.Uint64(b)
+	var checksum uint32
+	if rr.version >= storeVersionCRC {
+		if err := binary.Read(rr.r, enc, &checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	p := make([]byte, size)
+	if _, err := io.ReadFull(rr.r, p); err != nil {
+		return nil, err
+	}
+	if rr.version >= storeVersionCRC && crc32.Checksum(p, crcTable) != checksum {
+		return nil, errCorruptRecord
+	}
+	return p, nil
+}
@@ -1,6 +1,8 @@
 package log
 
 import (
+	"encoding/binary"
+	"hash/crc32"
 	"os"
 	"testing"
 
@@ -10,7 +12,7 @@ import (
 
 var (
 	write = []byte("hello world!!! you are awesome!!!")
-	width = uint64(len(write) + lenWidth)
+	width = uint64(len(write)) + frameWidth(storeVersion)
 )
 
 func TestStoreAppendRead(t *testing.T) {
@@ -35,13 +37,13 @@ func testAppend(t *testing.T, s *store) {
 	for i := uint64(1); i < 4; i++ {
 		n, pos, err := s.Append(write)
 		require.NoError(t, err)
-		require.Equal(t, pos+n, width*i)
+		require.Equal(t, pos+n, headerWidth+width*i)
 	}
 }
 
 func testRead(t *testing.T, s *store) {
 	t.Helper()
-	var pos uint64
+	pos := uint64(headerWidth)
 	for i := uint64(1); i < 4; i++ {
 		read, err := s.Read(pos)
 		require.NoError(t, err)
@@ -52,23 +54,76 @@ func testRead(t *testing.T, s *store) {
 
 func testReadAt(t *testing.T, s *store) {
 	t.Helper()
-	for i, off := uint64(1), int64(0); i < 4; i++ {
-		b := make([]byte, lenWidth)
+	for i, off := uint64(1), int64(headerWidth); i < 4; i++ {
+		b := make([]byte, frameWidth(storeVersion))
 		n, err := s.ReadAt(b, off)
 		require.NoError(t, err)
-		require.Equal(t, lenWidth, n)
+		require.Equal(t, len(b), n)
 		off += int64(n)
 
-		size := enc.Uint64(b)
+		size := enc.Uint64(b[:lenWidth])
+		checksum := enc.Uint32(b[lenWidth:])
 		b = make([]byte, size)
 		n, err = s.ReadAt(b, off)
 		require.NoError(t, err)
 		require.Equal(t, write, b)
 		require.Equal(t, int(size), n)
+		require.Equal(t, crc32.Checksum(write, crcTable), checksum)
 		off += int64(n)
 	}
 }
 
+func TestStoreCorruptRecord(t *testing.T) {
+	f := internal.GetTempFile(t, "", "store_corrupt_test")
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f)
+	require.NoError(t, err)
+
+	n, pos, err := s.Append(write)
+	require.NoError(t, err)
+	_, err = s.Read(pos)
+	require.NoError(t, err)
+
+	// flip a bit of the record's last byte
+	b := make([]byte, 1)
+	last := int64(pos + n - 1)
+	_, err = f.ReadAt(b, last)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{b[0] ^ 1}, last)
+	require.NoError(t, err)
+
+	_, err = s.Read(pos)
+	require.ErrorIs(t, err, errCorruptRecord)
+}
+
+func TestStoreLegacyFormat(t *testing.T) {
+	f := internal.GetTempFile(t, "", "store_legacy_test")
+	defer os.Remove(f.Name())
+
+	// legacy stores have no header and frame records without a checksum
+	err := binary.Write(f, enc, uint64(len(write)))
+	require.NoError(t, err)
+	_, err = f.Write(write)
+	require.NoError(t, err)
+
+	s, err := newStore(f)
+	require.NoError(t, err)
+	require.Equal(t, storeVersionLegacy, s.version)
+
+	read, err := s.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+
+	n, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(write)+lenWidth), n)
+
+	read, err = s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
 func TestStoreClose(t *testing.T) {
 	f := internal.GetTempFile(t, "", "store_close_test")
 	defer os.Remove(f.Name())
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+var (
+	tsWidth      uint64 = 8
+	timeEntWidth        = tsWidth + offWidth
+)
+
+// timeIndexInterval is the minimum amount of store bytes between two entries
+// of the time index.
+const timeIndexInterval = 4096
+
+// timeIndex is a sparse index from timestamps to offsets. Each entry holds
+// the highest timestamp of the segment's records up to its offset, so the
+// entries are sorted by both, even if clocks went backwards.
+type timeIndex struct {
+	file    *os.File
+	entries []timeEntry
+}
+
+type timeEntry struct {
+	timestamp int64
+	off       uint32
+}
+
+func newTimeIndex(f *os.File) (*timeIndex, error) {
+	t := &timeIndex{file: f}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	for pos := uint64(0); pos+timeEntWidth <= uint64(len(b)); pos += timeEntWidth {
+		e := timeEntry{
+			timestamp: int64(enc.Uint64(b[pos : pos+tsWidth])),
+			off:       enc.Uint32(b[pos+tsWidth : pos+timeEntWidth]),
+		}
+		if last, ok := t.last(); ok && (e.timestamp <= last.timestamp || e.off <= last.off) {
+			break
+		}
+		t.entries = append(t.entries, e)
+	}
+
+	// drop a partially written entry of an unclean shutdown
+	if size := uint64(len(t.entries)) * timeEntWidth; size < uint64(len(b)) {
+		if err = f.Truncate(int64(size)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *timeIndex) Write(timestamp int64, off uint32) error {
+	b := make([]byte, timeEntWidth)
+	enc.PutUint64(b[:tsWidth], uint64(timestamp))
+	enc.PutUint32(b[tsWidth:], off)
+	if _, err := t.file.Write(b); err != nil {
+		return err
+	}
+	t.entries = append(t.entries, timeEntry{timestamp: timestamp, off: off})
+	return nil
+}
+
+// Lookup returns the offset of the last entry older than timestamp. No record
+// up to that offset is at or after timestamp, so a search can start behind it.
+func (t *timeIndex) Lookup(timestamp int64) (off uint32, ok bool) {
+	i := sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].timestamp >= timestamp
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return t.entries[i-1].off, true
+}
+
+func (t *timeIndex) first() (timeEntry, bool) {
+	if len(t.entries) == 0 {
+		return timeEntry{}, false
+	}
+	return t.entries[0], true
+}
+
+func (t *timeIndex) last() (timeEntry, bool) {
+	if len(t.entries) == 0 {
+		return timeEntry{}, false
+	}
+	return t.entries[len(t.entries)-1], true
+}
+
+// truncate drops all entries at or behind off.
+func (t *timeIndex) truncate(off uint32) error {
+	n := sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].off >= off
+	})
+	if n == len(t.entries) {
+		return nil
+	}
+	if err := t.file.Truncate(int64(uint64(n) * timeEntWidth)); err != nil {
+		return err
+	}
+	t.entries = t.entries[:n]
+	return nil
+}
+
+func (t *timeIndex) Close() error {
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	return t.file.Close()
+}
+
+func (t *timeIndex) Name() string {
+	return t.file.Name()
+}
@@ -0,0 +1,62 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/justagabriel/proglog/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeIndex(t *testing.T) {
+	f := internal.GetTempFile(t, "", "timeindex_test")
+	defer os.Remove(f.Name())
+
+	ti, err := newTimeIndex(f)
+	require.NoError(t, err)
+	_, ok := ti.Lookup(100)
+	require.False(t, ok)
+
+	entries := []timeEntry{
+		{timestamp: 10, off: 0},
+		{timestamp: 20, off: 4},
+		{timestamp: 30, off: 9},
+	}
+	for _, e := range entries {
+		require.NoError(t, ti.Write(e.timestamp, e.off))
+	}
+
+	scenarios := map[int64]struct {
+		off uint32
+		ok  bool
+	}{
+		5:  {ok: false},
+		10: {ok: false},
+		11: {off: 0, ok: true},
+		20: {off: 0, ok: true},
+		25: {off: 4, ok: true},
+		31: {off: 9, ok: true},
+	}
+	for timestamp, want := range scenarios {
+		off, ok := ti.Lookup(timestamp)
+		require.Equal(t, want.ok, ok, "timestamp %d", timestamp)
+		require.Equal(t, want.off, off, "timestamp %d", timestamp)
+	}
+
+	// a torn entry is dropped when the time index is opened again
+	_, err = ti.file.Write([]byte{0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, ti.Close())
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	ti, err = newTimeIndex(f)
+	require.NoError(t, err)
+	require.Equal(t, entries, ti.entries)
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(entries))*int64(timeEntWidth), fi.Size())
+
+	require.NoError(t, ti.truncate(4))
+	require.Equal(t, entries[:1], ti.entries)
+	require.NoError(t, ti.Close())
+}
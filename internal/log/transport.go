@@ -0,0 +1,97 @@
+package log
+
+import (
+	"io"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// matchTransport reports the index up to which the log of a follower matches
+// the one of the leader whenever the follower acknowledges entries, which raft
+// keeps to itself.
+type matchTransport struct {
+	*raft.NetworkTransport
+	matched func(id raft.ServerID, index uint64)
+}
+
+func (t *matchTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	err := t.NetworkTransport.AppendEntries(id, target, args, resp)
+	if err == nil {
+		t.appended(id, args, resp)
+	}
+	return err
+}
+
+func (t *matchTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	err := t.NetworkTransport.InstallSnapshot(id, target, args, resp, data)
+	if err == nil && resp.Success {
+		t.matched(id, args.LastLogIndex)
+	}
+	return err
+}
+
+func (t *matchTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	pipeline, err := t.NetworkTransport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	p := &matchPipeline{
+		AppendPipeline: pipeline,
+		consumer:       make(chan raft.AppendFuture),
+		done:           make(chan struct{}),
+	}
+	go p.relay(func(f raft.AppendFuture) {
+		t.appended(id, f.Request(), f.Response())
+	})
+	return p, nil
+}
+
+// appended reports the last entry of args once the follower accepted them.
+// Heartbeats carry no entries and report 0.
+func (t *matchTransport) appended(id raft.ServerID, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) {
+	if !resp.Success {
+		return
+	}
+	index := args.PrevLogEntry
+	if n := len(args.Entries); n > 0 {
+		index = args.Entries[n-1].Index
+	}
+	t.matched(id, index)
+}
+
+// matchPipeline hands the responses of the pipeline to raft once they were
+// reported.
+type matchPipeline struct {
+	raft.AppendPipeline
+	consumer  chan raft.AppendFuture
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *matchPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.consumer
+}
+
+func (p *matchPipeline) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return p.AppendPipeline.Close()
+}
+
+func (p *matchPipeline) relay(appended func(raft.AppendFuture)) {
+	for {
+		select {
+		case <-p.done:
+			return
+		case f := <-p.AppendPipeline.Consumer():
+			if f.Error() == nil {
+				appended(f)
+			}
+			select {
+			case <-p.done:
+				return
+			case p.consumer <- f:
+			}
+		}
+	}
+}
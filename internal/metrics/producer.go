@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+	api "github.com/justagabriel/proglog/api/v1"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
+	"go.uber.org/zap"
+)
+
+// Log is the log whose segments and offsets are reported.
+type Log interface {
+	Segments() []*api.Segment
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+}
+
+// Raft reports the raft state of a server, e.g. a log.DistributedLog.
+type Raft interface {
+	RaftState() (*api.GetRaftStateResponse, error)
+}
+
+// Config selects the components a producer reports, nil ones are skipped.
+type Config struct {
+	Log  Log
+	Raft Raft
+	// Members returns the serf members, e.g. discovery.Membership.Members.
+	Members func() []serf.Member
+}
+
+type producer struct {
+	Config
+	logger *zap.Logger
+}
+
+// NewProducer returns a producer of gauges of the state of the components of
+// config, which are read whenever the metrics are.
+func NewProducer(config Config) metricproducer.Producer {
+	return &producer{Config: config, logger: zap.L().Named("metrics")}
+}
+
+var raftStates = []raft.RaftState{raft.Follower, raft.Candidate, raft.Leader, raft.Shutdown}
+
+var memberStatuses = []serf.MemberStatus{
+	serf.StatusNone,
+	serf.StatusAlive,
+	serf.StatusLeaving,
+	serf.StatusLeft,
+	serf.StatusFailed,
+}
+
+func (p *producer) Read() []*metricdata.Metric {
+	now := time.Now()
+	var metrics []*metricdata.Metric
+	add := func(name, description string, unit metricdata.Unit, value int64, labels ...string) {
+		// labels alternate between keys and values, metrics with the same
+		// name differ in their values
+		var keys []metricdata.LabelKey
+		var values []metricdata.LabelValue
+		for i := 0; i+1 < len(labels); i += 2 {
+			keys = append(keys, metricdata.LabelKey{Key: labels[i]})
+			values = append(values, metricdata.NewLabelValue(labels[i+1]))
+		}
+		ts := &metricdata.TimeSeries{
+			LabelValues: values,
+			Points:      []metricdata.Point{metricdata.NewInt64Point(now, value)},
+		}
+		if n := len(metrics); n > 0 && metrics[n-1].Descriptor.Name == name {
+			metrics[n-1].TimeSeries = append(metrics[n-1].TimeSeries, ts)
+			return
+		}
+		metrics = append(metrics, &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name:        name,
+				Description: description,
+				Unit:        unit,
+				Type:        metricdata.TypeGaugeInt64,
+				LabelKeys:   keys,
+			},
+			TimeSeries: []*metricdata.TimeSeries{ts},
+		})
+	}
+
+	if p.Log != nil {
+		var size uint64
+		segments := p.Log.Segments()
+		for _, s := range segments {
+			size += s.SizeBytes
+		}
+		add("proglog/log/segments", "Number of segments of the log", metricdata.UnitDimensionless, int64(len(segments)))
+		add("proglog/log/size_bytes", "Bytes of the segments of the log on disk", metricdata.UnitBytes, int64(size))
+		if lowest, err := p.Log.LowestOffset(); err == nil {
+			add("proglog/log/lowest_offset", "Offset of the oldest record of the log", metricdata.UnitDimensionless, int64(lowest))
+		}
+		if highest, err := p.Log.HighestOffset(); err == nil {
+			add("proglog/log/highest_offset", "Offset of the newest record of the log", metricdata.UnitDimensionless, int64(highest))
+		}
+	}
+
+	if p.Raft != nil {
+		state, err := p.Raft.RaftState()
+		if err != nil {
+			p.logger.Warn("failed to read raft state", zap.Error(err))
+		} else {
+			for _, s := range raftStates {
+				var current int64
+				if s.String() == state.State {
+					current = 1
+				}
+				add("proglog/raft/state", "Whether the raft state of the server is the state", metricdata.UnitDimensionless, current, "state", s.String())
+			}
+			add("proglog/raft/term", "Current raft term", metricdata.UnitDimensionless, int64(state.Term))
+			add("proglog/raft/last_index", "Index of the last raft log entry", metricdata.UnitDimensionless, int64(state.LastIndex))
+			add("proglog/raft/commit_index", "Index of the last committed raft log entry", metricdata.UnitDimensionless, int64(state.CommitIndex))
+			add("proglog/raft/applied_index", "Index of the last raft log entry applied to the log", metricdata.UnitDimensionless, int64(state.AppliedIndex))
+			for _, peer := range state.Peers {
+				if peer.IsLeader {
+					continue
+				}
+				// see api.Server.LagMs for what the server knows of the others
+				add("proglog/raft/last_contact", "Milliseconds since the follower was last in contact with the leader", metricdata.UnitMilliseconds, int64(peer.LagMs), "server_id", peer.Id)
+				add("proglog/raft/lag_entries", "Committed raft log entries the follower is yet to replicate", metricdata.UnitDimensionless, int64(peer.LagEntries), "server_id", peer.Id)
+			}
+		}
+	}
+
+	if p.Members != nil {
+		counts := make(map[serf.MemberStatus]int64)
+		for _, m := range p.Members() {
+			counts[m.Status]++
+		}
+		for _, status := range memberStatuses {
+			add("proglog/serf/members", "Number of serf members in the status", metricdata.UnitDimensionless, counts[status], "status", status.String())
+		}
+	}
+	return metrics
+}
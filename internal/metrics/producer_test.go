@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/serf/serf"
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducer(t *testing.T) {
+	// arrange
+	p := NewProducer(Config{
+		Log:  fakeLog{},
+		Raft: fakeRaft{},
+		Members: func() []serf.Member {
+			return []serf.Member{{Status: serf.StatusAlive}, {Status: serf.StatusAlive}, {Status: serf.StatusFailed}}
+		},
+	})
+
+	// act
+	var b bytes.Buffer
+	err := Write(&b, p.Read())
+
+	// assert
+	require.NoError(t, err)
+	for _, sample := range []string{
+		"proglog_log_segments 2\n",
+		"proglog_log_size_bytes 300\n",
+		"proglog_log_lowest_offset 10\n",
+		"proglog_log_highest_offset 41\n",
+		`proglog_raft_state{state="Leader"} 1` + "\n",
+		`proglog_raft_state{state="Follower"} 0` + "\n",
+		"proglog_raft_term 3\n",
+		"proglog_raft_commit_index 20\n",
+		"proglog_raft_applied_index 19\n",
+		`proglog_raft_last_contact{server_id="b"} 1500` + "\n",
+		`proglog_raft_lag_entries{server_id="b"} 7` + "\n",
+		`proglog_serf_members{status="alive"} 2` + "\n",
+		`proglog_serf_members{status="failed"} 1` + "\n",
+		`proglog_serf_members{status="left"} 0` + "\n",
+	} {
+		require.Contains(t, b.String(), sample)
+	}
+	require.NotContains(t, b.String(), `server_id="a"`)
+}
+
+type fakeLog struct{}
+
+func (fakeLog) Segments() []*api.Segment {
+	return []*api.Segment{{BaseOffset: 10, SizeBytes: 100}, {BaseOffset: 20, SizeBytes: 200}}
+}
+
+func (fakeLog) LowestOffset() (uint64, error) {
+	return 10, nil
+}
+
+func (fakeLog) HighestOffset() (uint64, error) {
+	return 41, nil
+}
+
+type fakeRaft struct{}
+
+func (fakeRaft) RaftState() (*api.GetRaftStateResponse, error) {
+	return &api.GetRaftStateResponse{
+		State:        "Leader",
+		Term:         3,
+		LastIndex:    21,
+		CommitIndex:  20,
+		AppliedIndex: 19,
+		Peers: []*api.Server{
+			{Id: "a", IsLeader: true},
+			{Id: "b", LagMs: 1500, LagEntries: 7},
+		},
+	}, nil
+}
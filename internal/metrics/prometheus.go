@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
+	"go.uber.org/zap"
+)
+
+// contentType is the content type of the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics of the OpenCensus views, e.g. the gRPC server
+// views, and of producers in the Prometheus text exposition format.
+func Handler(producers ...metricproducer.Producer) http.Handler {
+	logger := zap.L().Named("metrics")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metrics []*metricdata.Metric
+		for _, p := range append(metricproducer.GlobalManager().GetAll(), producers...) {
+			metrics = append(metrics, p.Read()...)
+		}
+		w.Header().Set("Content-Type", contentType)
+		if err := Write(w, metrics); err != nil {
+			logger.Debug("failed to write metrics", zap.Error(err))
+		}
+	})
+}
+
+// Write writes metrics in the Prometheus text exposition format. Their names
+// are sanitized, so the view grpc.io/server/completed_rpcs is written as
+// grpc_io_server_completed_rpcs. Distributions are written as histograms.
+func Write(w io.Writer, metrics []*metricdata.Metric) error {
+	slices.SortStableFunc(metrics, func(a, b *metricdata.Metric) int {
+		return strings.Compare(a.Descriptor.Name, b.Descriptor.Name)
+	})
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		writeMetric(bw, m)
+	}
+	return bw.Flush()
+}
+
+func writeMetric(w *bufio.Writer, m *metricdata.Metric) {
+	name := sanitize(m.Descriptor.Name)
+	if m.Descriptor.Description != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, helpEscaper.Replace(m.Descriptor.Description))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType(m.Descriptor.Type))
+
+	keys := make([]string, len(m.Descriptor.LabelKeys))
+	for i, key := range m.Descriptor.LabelKeys {
+		keys[i] = sanitize(key.Key)
+	}
+	for _, ts := range m.TimeSeries {
+		if len(ts.Points) == 0 {
+			continue
+		}
+		labels := labelPairs(keys, ts.LabelValues)
+		// the last point is the current value
+		switch v := ts.Points[len(ts.Points)-1].Value.(type) {
+		case int64:
+			writeSample(w, name, labels, float64(v))
+		case float64:
+			writeSample(w, name, labels, v)
+		case *metricdata.Distribution:
+			var count int64
+			for i, bucket := range v.Buckets {
+				count += bucket.Count
+				le := math.Inf(1)
+				if v.BucketOptions != nil && i < len(v.BucketOptions.Bounds) {
+					le = v.BucketOptions.Bounds[i]
+				}
+				writeSample(w, name+"_bucket", append(labels, labelPair("le", formatFloat(le))), float64(count))
+			}
+			if len(v.Buckets) == 0 {
+				writeSample(w, name+"_bucket", append(labels, labelPair("le", "+Inf")), float64(v.Count))
+			}
+			writeSample(w, name+"_sum", labels, v.Sum)
+			writeSample(w, name+"_count", labels, float64(v.Count))
+		case *metricdata.Summary:
+			percentiles := make([]float64, 0, len(v.Snapshot.Percentiles))
+			for p := range v.Snapshot.Percentiles {
+				percentiles = append(percentiles, p)
+			}
+			slices.Sort(percentiles)
+			for _, p := range percentiles {
+				quantile := labelPair("quantile", formatFloat(p/100))
+				writeSample(w, name, append(labels, quantile), v.Snapshot.Percentiles[p])
+			}
+			if v.HasCountAndSum {
+				writeSample(w, name+"_sum", labels, v.Sum)
+				writeSample(w, name+"_count", labels, float64(v.Count))
+			}
+		}
+	}
+}
+
+func metricType(t metricdata.Type) string {
+	switch t {
+	case metricdata.TypeGaugeInt64, metricdata.TypeGaugeFloat64:
+		return "gauge"
+	case metricdata.TypeCumulativeInt64, metricdata.TypeCumulativeFloat64:
+		return "counter"
+	case metricdata.TypeGaugeDistribution, metricdata.TypeCumulativeDistribution:
+		return "histogram"
+	case metricdata.TypeSummary:
+		return "summary"
+	default:
+		return "untyped"
+	}
+}
+
+// labelPairs returns the pairs of the keys and their present values.
+func labelPairs(keys []string, values []metricdata.LabelValue) []string {
+	pairs := make([]string, 0, len(keys)+1)
+	for i, key := range keys {
+		if i < len(values) && values[i].Present {
+			pairs = append(pairs, labelPair(key, values[i].Value))
+		}
+	}
+	return pairs
+}
+
+func labelPair(key, value string) string {
+	return key + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// sanitize replaces the characters Prometheus doesn't allow in names with
+// underscores, and prefixes names starting with a digit with one.
+func sanitize(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':') {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
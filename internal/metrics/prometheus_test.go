@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/metric/metricdata"
+)
+
+func TestWrite(t *testing.T) {
+	// arrange
+	now := time.Now()
+	metrics := []*metricdata.Metric{
+		{
+			Descriptor: metricdata.Descriptor{
+				Name:        "proglog/read_latency",
+				Description: "Read\nlatency",
+				Type:        metricdata.TypeCumulativeDistribution,
+				LabelKeys:   []metricdata.LabelKey{{Key: "method"}},
+			},
+			TimeSeries: []*metricdata.TimeSeries{{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(`"Get"`)},
+				Points: []metricdata.Point{metricdata.NewDistributionPoint(now, &metricdata.Distribution{
+					Count:         3,
+					Sum:           7.5,
+					BucketOptions: &metricdata.BucketOptions{Bounds: []float64{1, 5}},
+					Buckets:       []metricdata.Bucket{{Count: 1}, {Count: 1}, {Count: 1}},
+				})},
+			}},
+		},
+		{
+			Descriptor: metricdata.Descriptor{
+				Name:      "grpc.io/server/completed_rpcs",
+				Type:      metricdata.TypeCumulativeInt64,
+				LabelKeys: []metricdata.LabelKey{{Key: "grpc_server_method"}, {Key: "grpc_server_status"}},
+			},
+			TimeSeries: []*metricdata.TimeSeries{{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue("log.v1.Log/Get"), {}},
+				Points:      []metricdata.Point{metricdata.NewInt64Point(now, 1), metricdata.NewInt64Point(now, 2)},
+			}},
+		},
+		{
+			Descriptor: metricdata.Descriptor{Name: "proglog/ratio", Type: metricdata.TypeGaugeFloat64},
+			TimeSeries: []*metricdata.TimeSeries{{
+				Points: []metricdata.Point{metricdata.NewFloat64Point(now, 0.25)},
+			}},
+		},
+	}
+
+	// act
+	var b bytes.Buffer
+	err := Write(&b, metrics)
+
+	// assert
+	require.NoError(t, err)
+	require.Equal(t, `# TYPE grpc_io_server_completed_rpcs counter
+grpc_io_server_completed_rpcs{grpc_server_method="log.v1.Log/Get"} 2
+# TYPE proglog_ratio gauge
+proglog_ratio 0.25
+# HELP proglog_read_latency Read\nlatency
+# TYPE proglog_read_latency histogram
+proglog_read_latency_bucket{method="\"Get\"",le="1"} 1
+proglog_read_latency_bucket{method="\"Get\"",le="5"} 2
+proglog_read_latency_bucket{method="\"Get\"",le="+Inf"} 3
+proglog_read_latency_sum{method="\"Get\""} 7.5
+proglog_read_latency_count{method="\"Get\""} 3
+`, b.String())
+}
+
+func TestHandler(t *testing.T) {
+	// arrange
+	handler := Handler(NewProducer(Config{Log: fakeLog{}}))
+	rec := httptest.NewRecorder()
+
+	// act
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// assert
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), "proglog_log_segments 2\n")
+}
+
+func TestSanitize(t *testing.T) {
+	require.Equal(t, "grpc_io_server_server_latency", sanitize("grpc.io/server/server_latency"))
+	require.Equal(t, "_1st", sanitize("1st"))
+	require.Equal(t, "a:b_c", sanitize("a:b-c"))
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/justagabriel/proglog/api/v1"
+)
+
+// The actions of the Admin service, which are authorized separately from
+// the ones of the Log service.
+const (
+	getRaftStateAction       string = "get-raft-state"
+	addPeerAction            string = "add-peer"
+	removePeerAction         string = "remove-peer"
+	transferLeadershipAction string = "transfer-leadership"
+	snapshotAction           string = "snapshot"
+	getSegmentsAction        string = "get-segments"
+	drainAction              string = "drain"
+)
+
+// Cluster is the raft cluster and log managed by the Admin service. Changes of
+// the raft configuration fail with api.ErrNotLeader on followers.
+type Cluster interface {
+	RaftState() (*api.GetRaftStateResponse, error)
+	AddPeer(id, addr string, voter bool) error
+	RemovePeer(id string) error
+	TransferLeadership(id string) error
+	Snapshot() (index, term uint64, err error)
+	Segments() []*api.Segment
+}
+
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*Config
+	drain *Drain
+}
+
+var _ api.AdminServer = (*adminServer)(nil)
+
+func (s *adminServer) GetRaftState(ctx context.Context, req *api.GetRaftStateRequest) (*api.GetRaftStateResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), getRaftStateAction)
+	if err != nil {
+		return nil, err
+	}
+	return s.Cluster.RaftState()
+}
+
+func (s *adminServer) AddPeer(ctx context.Context, req *api.AddPeerRequest) (*api.AddPeerResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), addPeerAction)
+	if err != nil {
+		return nil, err
+	}
+	voter := req.Suffrage != api.Suffrage_SUFFRAGE_NONVOTER
+	if err = s.Cluster.AddPeer(req.Id, req.RpcAddr, voter); err != nil {
+		return nil, err
+	}
+	return &api.AddPeerResponse{}, nil
+}
+
+func (s *adminServer) RemovePeer(ctx context.Context, req *api.RemovePeerRequest) (*api.RemovePeerResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), removePeerAction)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Cluster.RemovePeer(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.RemovePeerResponse{}, nil
+}
+
+func (s *adminServer) TransferLeadership(ctx context.Context, req *api.TransferLeadershipRequest) (*api.TransferLeadershipResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), transferLeadershipAction)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Cluster.TransferLeadership(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.TransferLeadershipResponse{}, nil
+}
+
+func (s *adminServer) Snapshot(ctx context.Context, req *api.SnapshotRequest) (*api.SnapshotResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), snapshotAction)
+	if err != nil {
+		return nil, err
+	}
+	index, term, err := s.Cluster.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &api.SnapshotResponse{Index: index, Term: term}, nil
+}
+
+func (s *adminServer) GetSegments(ctx context.Context, req *api.GetSegmentsRequest) (*api.GetSegmentsResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), getSegmentsAction)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetSegmentsResponse{Segments: s.Cluster.Segments()}, nil
+}
+
+func (s *adminServer) Drain(ctx context.Context, req *api.DrainRequest) (*api.DrainResponse, error) {
+	err := s.Authorizer.Authorize(subject(ctx), drainAction)
+	if err != nil {
+		return nil, err
+	}
+	s.drain.Run(s.Cluster)
+	return &api.DrainResponse{}, nil
+}
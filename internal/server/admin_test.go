@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cluster records the changes requested through the Admin service.
+type cluster struct {
+	peers       map[string]*api.Server
+	transferTo  string
+	transferred bool
+	snapshotted bool
+}
+
+func (c *cluster) RaftState() (*api.GetRaftStateResponse, error) {
+	state := &api.GetRaftStateResponse{State: "Leader", Term: 2, CommitIndex: 7, AppliedIndex: 7}
+	for _, peer := range c.peers {
+		state.Peers = append(state.Peers, peer)
+	}
+	return state, nil
+}
+
+func (c *cluster) AddPeer(id, addr string, voter bool) error {
+	suffrage := api.Suffrage_SUFFRAGE_VOTER
+	if !voter {
+		suffrage = api.Suffrage_SUFFRAGE_NONVOTER
+	}
+	c.peers[id] = &api.Server{Id: id, RpcAddr: addr, Suffrage: suffrage}
+	return nil
+}
+
+func (c *cluster) RemovePeer(id string) error {
+	delete(c.peers, id)
+	return nil
+}
+
+func (c *cluster) TransferLeadership(id string) error {
+	c.transferTo = id
+	c.transferred = true
+	return nil
+}
+
+func (c *cluster) Snapshot() (uint64, uint64, error) {
+	c.snapshotted = true
+	return 7, 2, nil
+}
+
+func (c *cluster) Segments() []*api.Segment {
+	return []*api.Segment{{BaseOffset: 0, NextOffset: 3, Sealed: true}, {BaseOffset: 3, NextOffset: 3, Open: true}}
+}
+
+func TestAdmin(t *testing.T) {
+	// arrange
+	c := &cluster{peers: make(map[string]*api.Server)}
+	setup := SetupTest(t, func(config *Config) {
+		config.Cluster = c
+	}, debug)
+	defer setup.Teardown()
+	ctx := context.Background()
+	client := setup.AuthorizedAdminClient
+
+	// act
+	_, err := client.AddPeer(ctx, &api.AddPeerRequest{
+		Id:       "replica",
+		RpcAddr:  "localhost:9003",
+		Suffrage: api.Suffrage_SUFFRAGE_NONVOTER,
+	})
+	require.NoError(t, err)
+	_, err = client.AddPeer(ctx, &api.AddPeerRequest{Id: "voter", RpcAddr: "localhost:9004"})
+	require.NoError(t, err)
+	_, err = client.RemovePeer(ctx, &api.RemovePeerRequest{Id: "voter"})
+	require.NoError(t, err)
+	_, err = client.TransferLeadership(ctx, &api.TransferLeadershipRequest{Id: "replica"})
+	require.NoError(t, err)
+	snapshot, err := client.Snapshot(ctx, &api.SnapshotRequest{})
+	require.NoError(t, err)
+	state, err := client.GetRaftState(ctx, &api.GetRaftStateRequest{})
+	require.NoError(t, err)
+	segments, err := client.GetSegments(ctx, &api.GetSegmentsRequest{})
+	require.NoError(t, err)
+
+	// assert
+	require.Len(t, state.Peers, 1)
+	require.Equal(t, "replica", state.Peers[0].Id)
+	require.Equal(t, api.Suffrage_SUFFRAGE_NONVOTER, state.Peers[0].Suffrage)
+	require.Equal(t, uint64(7), state.AppliedIndex)
+	require.Equal(t, "replica", c.transferTo)
+	require.True(t, c.snapshotted)
+	require.Equal(t, uint64(7), snapshot.Index)
+	require.Len(t, segments.Segments, 2)
+	require.True(t, segments.Segments[0].Sealed)
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	// arrange
+	c := &cluster{peers: make(map[string]*api.Server)}
+	setup := SetupTest(t, func(config *Config) {
+		config.Cluster = c
+	}, debug)
+	defer setup.Teardown()
+	ctx := context.Background()
+	client := setup.UnauthorizedAdminClient
+
+	// act
+	errs := make([]error, 0, 7)
+	_, err := client.GetRaftState(ctx, &api.GetRaftStateRequest{})
+	errs = append(errs, err)
+	_, err = client.AddPeer(ctx, &api.AddPeerRequest{Id: "replica", RpcAddr: "localhost:9003"})
+	errs = append(errs, err)
+	_, err = client.RemovePeer(ctx, &api.RemovePeerRequest{Id: "replica"})
+	errs = append(errs, err)
+	_, err = client.TransferLeadership(ctx, &api.TransferLeadershipRequest{})
+	errs = append(errs, err)
+	_, err = client.Snapshot(ctx, &api.SnapshotRequest{})
+	errs = append(errs, err)
+	_, err = client.GetSegments(ctx, &api.GetSegmentsRequest{})
+	errs = append(errs, err)
+	_, err = client.Drain(ctx, &api.DrainRequest{})
+	errs = append(errs, err)
+
+	// assert
+	for _, err := range errs {
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+	require.Empty(t, c.peers)
+	require.False(t, c.snapshotted)
+	require.False(t, c.transferred)
+}
+
+func TestAdminNotServedWithoutCluster(t *testing.T) {
+	setup := SetupTest(t, nil, debug)
+	defer setup.Teardown()
+
+	_, err := setup.AuthorizedAdminClient.GetRaftState(context.Background(), &api.GetRaftStateRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"sync"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+// Drain takes a server out of service before it's stopped, e.g. for a
+// rolling restart. Once started, new streams are rejected. Once the leadership
+// is handed off, active streams are ended with api.ErrDraining, so clients
+// resume them at another server.
+type Drain struct {
+	mu       sync.Mutex
+	started  bool
+	draining chan struct{}
+	ending   chan struct{}
+}
+
+// NewDrain returns a Drain that wasn't started yet.
+func NewDrain() *Drain {
+	return &Drain{
+		draining: make(chan struct{}),
+		ending:   make(chan struct{}),
+	}
+}
+
+// Run drains the server, handing the leadership of the cluster to the most
+// up to date follower before the streams are ended. It returns once the
+// server is drained and does nothing if it was drained before.
+func (d *Drain) Run(cluster Cluster) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started {
+		return
+	}
+	d.started = true
+
+	close(d.draining)
+	if cluster != nil {
+		err := cluster.TransferLeadership("")
+		if _, notLeader := err.(api.ErrNotLeader); err != nil && !notLeader {
+			// e.g. a single server doesn't have a follower to take over
+			zap.L().Named("server").Warn("failed to transfer leadership", zap.Error(err))
+		}
+	}
+	close(d.ending)
+}
+
+// Draining is closed once the server stops accepting streams.
+func (d *Drain) Draining() <-chan struct{} {
+	return d.draining
+}
+
+// Ending is closed once the active streams are to be ended.
+func (d *Drain) Ending() <-chan struct{} {
+	return d.ending
+}
+
+// isClosed reports whether c is closed without blocking.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	// arrange
+	c := &cluster{peers: make(map[string]*api.Server)}
+	setup := SetupTest(t, func(config *Config) {
+		config.Cluster = c
+	}, debug)
+	defer setup.Teardown()
+	ctx := context.Background()
+	client := setup.AuthorizedClient
+
+	_, err := client.Create(ctx, &api.CreateRecordRequest{Record: &api.Record{Value: []byte("first")}})
+	require.NoError(t, err)
+	stream, err := client.GetStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&api.GetRecordRequest{Offset: 0}))
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Record.Offset)
+
+	// act
+	_, err = setup.AuthorizedAdminClient.Drain(ctx, &api.DrainRequest{})
+	require.NoError(t, err)
+
+	// assert
+	_, err = stream.Recv()
+	drainErr, ok := api.AsErrDraining(err)
+	require.True(t, ok, err)
+	require.Equal(t, uint64(1), drainErr.ResumeOffset)
+
+	newStream, err := client.GetStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, newStream.Send(&api.GetRecordRequest{Offset: 0}))
+	_, err = newStream.Recv()
+	_, ok = api.AsErrDraining(err)
+	require.True(t, ok, err)
+
+	// unary requests are still served until the server stops
+	_, err = client.Create(ctx, &api.CreateRecordRequest{Record: &api.Record{Value: []byte("second")}})
+	require.NoError(t, err)
+
+	require.True(t, c.transferred)
+	require.Empty(t, c.transferTo)
+
+	// draining twice does nothing
+	_, err = setup.AuthorizedAdminClient.Drain(ctx, &api.DrainRequest{})
+	require.NoError(t, err)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// subjectMetadataKey carries the subject of the original caller of a
+// forwarded request. Servers only accept it from peers authorized to
+// forwardAction.
+const subjectMetadataKey = "proglog-subject"
+
+// errForwarderClosed fails the requests forwarded after the server stopped.
+var errForwarderClosed = errors.New("forwarder closed")
+
+// forwarder sends writes and linearizable reads which reached a follower to
+// the leader on behalf of their caller. It keeps a single connection to the
+// current leader until it's closed.
+type forwarder struct {
+	opts []grpc.DialOption
+
+	mu     sync.Mutex
+	closed bool
+	addr   string
+	conn   *grpc.ClientConn
+	client api.LogClient
+}
+
+func newForwarder(opts []grpc.DialOption) *forwarder {
+	return &forwarder{opts: opts}
+}
+
+// leader returns a client of the server at addr, replacing the connection
+// to the previous leader.
+func (f *forwarder) leader(addr string) (api.LogClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, errForwarderClosed
+	}
+	if f.conn != nil && f.addr == addr {
+		return f.client, nil
+	}
+	conn, err := grpc.Dial(addr, f.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.addr, f.conn, f.client = addr, conn, api.NewLogClient(conn)
+	return f.client, nil
+}
+
+// Close closes the connection to the leader. Requests forwarded afterwards
+// fail with errForwarderClosed.
+func (f *forwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.conn == nil {
+		return nil
+	}
+	conn := f.conn
+	f.addr, f.conn, f.client = "", nil, nil
+	return conn.Close()
+}
+
+// context returns the context of a request forwarded for the caller of ctx.
+func (f *forwarder) context(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, subjectMetadataKey, subject(ctx))
+}
+
+func (f *forwarder) Create(ctx context.Context, leaderAddr string, req *api.CreateRecordRequest) (*api.CreateRecordResponse, error) {
+	client, err := f.leader(leaderAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.Create(f.context(ctx), req)
+}
+
+func (f *forwarder) CreateBatch(ctx context.Context, leaderAddr string, req *api.CreateBatchRequest) (*api.CreateBatchResponse, error) {
+	client, err := f.leader(leaderAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateBatch(f.context(ctx), req)
+}
+
+func (f *forwarder) Get(ctx context.Context, leaderAddr string, req *api.GetRecordRequest) (*api.GetRecordResponse, error) {
+	client, err := f.leader(leaderAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(f.context(ctx), req)
+}
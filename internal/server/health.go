@@ -0,0 +1,98 @@
+package server
+
+import (
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The health service reports whether the server serves reads and writes of
+// the Log service under these names, and whether it serves reads under "",
+// the name of the server as a whole.
+const (
+	ReadHealthService  = "log.v1.Log.read"
+	WriteHealthService = "log.v1.Log.write"
+)
+
+// healthInterval is the interval at which the health of replicated logs is
+// checked between leader changes, e.g. while they apply a snapshot.
+const healthInterval = time.Second
+
+// Healther is implemented by replicated commit logs, which can't serve
+// requests without a leader.
+type Healther interface {
+	// Health reports whether the server knows a leader, whether it's the
+	// leader itself and whether its log applied the committed entries, up
+	// to a lag.
+	Health() (hasLeader, isLeader, caughtUp bool)
+	// HealthChanged returns a channel which is closed by the next change of
+	// the leader.
+	HealthChanged() <-chan struct{}
+}
+
+// healthStatuses returns the statuses of the health services. Servers serve
+// reads once they know a leader and caught up with the log, and writes once
+// they know a leader which is either them or one to forward the writes to.
+// Draining servers serve neither.
+func (s *grpcServer) healthStatuses() map[string]healthpb.HealthCheckResponse_ServingStatus {
+	reads, writes := true, true
+	if healther, ok := s.CommitLog.(Healther); ok {
+		hasLeader, isLeader, caughtUp := healther.Health()
+		reads = hasLeader && caughtUp
+		writes = isLeader || hasLeader && s.forwarder != nil
+	}
+	if isClosed(s.drain.Draining()) {
+		reads, writes = false, false
+	}
+	return map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":                 servingStatus(reads),
+		ReadHealthService:  servingStatus(reads),
+		WriteHealthService: servingStatus(writes),
+	}
+}
+
+func servingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// updateHealth sets the statuses of hsrv and returns a channel which is
+// closed once they may have changed.
+func (s *grpcServer) updateHealth(hsrv *health.Server) <-chan struct{} {
+	var changed <-chan struct{}
+	if healther, ok := s.CommitLog.(Healther); ok {
+		// taken first, so changes while the statuses are set aren't missed
+		changed = healther.HealthChanged()
+	}
+	for service, status := range s.healthStatuses() {
+		hsrv.SetServingStatus(service, status)
+	}
+	return changed
+}
+
+// watchHealth keeps the statuses of hsrv up to date, starting with the ones
+// returned by updateHealth, until the server drains or stop is closed.
+func (s *grpcServer) watchHealth(hsrv *health.Server, changed <-chan struct{}, stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if changed != nil {
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.drain.Draining():
+			// reports NOT_SERVING for all services from now on
+			hsrv.Shutdown()
+			return
+		case <-changed:
+		case <-tick:
+		}
+		changed = s.updateHealth(hsrv)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/justagabriel/proglog/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth(t *testing.T) {
+	// arrange
+	hl := &healthLog{changed: make(chan struct{})}
+	setup := SetupTest(t, func(c *Config) {
+		hl.CommitLog = c.CommitLog
+		c.CommitLog = hl
+		c.Drain = NewDrain()
+	}, debug)
+	defer setup.Teardown()
+	nobody, baseURL := setupHTTPServer(t, setup.Server, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+
+	requireStatuses := func(reads, writes healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			for service, want := range map[string]healthpb.HealthCheckResponse_ServingStatus{
+				"":                 reads,
+				ReadHealthService:  reads,
+				WriteHealthService: writes,
+			} {
+				res, err := setup.HealthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+				if assert.NoError(c, err) {
+					assert.Equal(c, want, res.Status, service)
+				}
+			}
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// act & assert
+	// without a leader the server serves neither reads nor writes
+	requireStatuses(healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	// followers without forwarding serve reads once they caught up
+	hl.set(true, false, false)
+	requireStatuses(healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_NOT_SERVING)
+	hl.set(true, false, true)
+	requireStatuses(healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	hl.set(true, true, true)
+	requireStatuses(healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_SERVING)
+
+	health := &healthpb.HealthCheckResponse{}
+	code := doHTTP(t, nobody, http.MethodGet, baseURL+"/v1/health?service="+WriteHealthService, "", health)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+
+	// draining servers serve neither
+	setup.Config.Drain.Run(&cluster{})
+	requireStatuses(healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_NOT_SERVING)
+	code = doHTTP(t, nobody, http.MethodGet, baseURL+"/v1/health?service="+ReadHealthService, "", health)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, health.Status)
+}
+
+func TestHealthStopsWithServer(t *testing.T) {
+	// arrange
+	hl := &healthLog{changed: make(chan struct{})}
+	server, err := NewGRPCServer(&Config{CommitLog: hl})
+	require.NoError(t, err)
+	stopped := make(chan struct{})
+	go func() {
+		server.srv.watchHealth(health.NewServer(), hl.HealthChanged(), server.stopHealth)
+		close(stopped)
+	}()
+
+	// act
+	// the server stops without draining first
+	server.Stop()
+
+	// assert
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		require.Fail(t, "the health is still watched")
+	}
+}
+
+// healthLog reports the health it is set to.
+type healthLog struct {
+	CommitLog
+	mu                            sync.Mutex
+	hasLeader, isLeader, caughtUp bool
+	changed                       chan struct{}
+}
+
+func (l *healthLog) Health() (hasLeader, isLeader, caughtUp bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hasLeader, l.isLeader, l.caughtUp
+}
+
+func (l *healthLog) HealthChanged() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+func (l *healthLog) set(hasLeader, isLeader, caughtUp bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hasLeader, l.isLeader, l.caughtUp = hasLeader, isLeader, caughtUp
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
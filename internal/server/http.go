@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxHTTPBodyBytes bounds the bodies of HTTP requests.
+const maxHTTPBodyBytes = 16 << 20
+
+// httpServer serves the Log service as JSON over HTTP for clients which can't
+// speak gRPC. Its handlers call the grpcServer, so both authorize, forward and
+// read alike. Requests and responses are the JSON mapping of the api messages.
+type httpServer struct {
+	*grpcServer
+	logger *zap.Logger
+}
+
+// NewHTTPServer creates the HTTP/JSON gateway of gsrv, which shares its
+// forwarder and drain. It authenticates callers by their client certificates
+// and bearer tokens like the gRPC server.
+//
+//	POST /v1/records          CreateRecordRequest -> CreateRecordResponse
+//	POST /v1/records/batch    CreateBatchRequest -> CreateBatchResponse
+//	GET  /v1/records/{offset} ?consistency=&min_offset= -> GetRecordResponse
+//	GET  /v1/records          ?offset=&max_records=&max_bytes= -> ReadRangeResponse
+//	GET  /v1/offsets          GetOffsetsResponse
+//	GET  /v1/servers          GetServersResponse
+//	GET  /v1/tail             ?offset= -> Server-Sent Events or WebSocket of Records
+//	GET  /v1/health           ?service= -> HealthCheckResponse, 503 unless SERVING
+//	GET  /metrics             config.Metrics, if set
+//
+// Errors respond with the JSON of their google.rpc.Status and the HTTP status
+// matching its code.
+func NewHTTPServer(gsrv *GRPCServer) *http.Server {
+	h := &httpServer{grpcServer: gsrv.srv, logger: zap.L().Named("http")}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/records", h.route(map[string]httpHandler{
+		http.MethodPost: h.create,
+		http.MethodGet:  h.readRange,
+	}))
+	mux.Handle("/v1/records/batch", h.route(map[string]httpHandler{
+		http.MethodPost: h.createBatch,
+	}))
+	mux.Handle("/v1/records/", h.route(map[string]httpHandler{
+		http.MethodGet: h.get,
+	}))
+	mux.Handle("/v1/offsets", h.route(map[string]httpHandler{
+		http.MethodGet: h.getOffsets,
+	}))
+	mux.Handle("/v1/servers", h.route(map[string]httpHandler{
+		http.MethodGet: h.getServers,
+	}))
+	mux.Handle("/v1/tail", http.HandlerFunc(h.tail))
+	mux.Handle("/v1/health", http.HandlerFunc(h.health))
+	if h.Metrics != nil {
+		mux.Handle("/metrics", h.Metrics)
+	}
+
+	return &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+type httpHandler func(ctx context.Context, r *http.Request) (proto.Message, error)
+
+// route returns a handler calling the handler of the request's method with
+// the subject of the caller.
+func (h *httpServer) route(handlers map[string]httpHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.Method]
+		if !ok {
+			var allowed []string
+			for method := range handlers {
+				allowed = append(allowed, method)
+			}
+			h.methodNotAllowed(w, r, allowed...)
+			return
+		}
+
+		subject, err := h.authenticate(r)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), subjectContextKey{}, subject)
+		res, err := handler(ctx, r)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		h.write(w, http.StatusOK, res)
+	})
+}
+
+func (h *httpServer) create(ctx context.Context, r *http.Request) (proto.Message, error) {
+	req := &api.CreateRecordRequest{}
+	if err := readJSON(r, req); err != nil {
+		return nil, err
+	}
+	return h.Create(ctx, req)
+}
+
+func (h *httpServer) createBatch(ctx context.Context, r *http.Request) (proto.Message, error) {
+	req := &api.CreateBatchRequest{}
+	if err := readJSON(r, req); err != nil {
+		return nil, err
+	}
+	return h.CreateBatch(ctx, req)
+}
+
+func (h *httpServer) get(ctx context.Context, r *http.Request) (proto.Message, error) {
+	req := &api.GetRecordRequest{}
+	var err error
+	req.Offset, err = parseUint(strings.TrimPrefix(r.URL.Path, "/v1/records/"), "offset")
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	if query.Has("min_offset") {
+		req.Consistency = api.ReadConsistency_READ_CONSISTENCY_MIN_OFFSET
+		if req.MinOffset, err = parseUint(query.Get("min_offset"), "min_offset"); err != nil {
+			return nil, err
+		}
+	}
+	if consistency := query.Get("consistency"); consistency != "" {
+		value, ok := api.ReadConsistency_value["READ_CONSISTENCY_"+strings.ToUpper(consistency)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown consistency %q", consistency)
+		}
+		req.Consistency = api.ReadConsistency(value)
+	}
+	return h.Get(ctx, req)
+}
+
+func (h *httpServer) readRange(ctx context.Context, r *http.Request) (proto.Message, error) {
+	req := &api.ReadRangeRequest{}
+	query := r.URL.Query()
+	var err error
+	if query.Has("offset") {
+		if req.Offset, err = parseUint(query.Get("offset"), "offset"); err != nil {
+			return nil, err
+		}
+	}
+	if query.Has("max_records") {
+		var maxRecords uint64
+		if maxRecords, err = parseUint(query.Get("max_records"), "max_records"); err != nil {
+			return nil, err
+		}
+		req.MaxRecords = uint32(min(maxRecords, maxReadRangeRecords))
+	}
+	if query.Has("max_bytes") {
+		if req.MaxBytes, err = parseUint(query.Get("max_bytes"), "max_bytes"); err != nil {
+			return nil, err
+		}
+	}
+	return h.ReadRange(ctx, req)
+}
+
+func (h *httpServer) getOffsets(ctx context.Context, r *http.Request) (proto.Message, error) {
+	return h.GetOffsets(ctx, &api.GetOffsetsRequest{})
+}
+
+func (h *httpServer) getServers(ctx context.Context, r *http.Request) (proto.Message, error) {
+	return h.GetServers(ctx, &api.GetServersRequest{})
+}
+
+// health reports the status of the health service of the service query
+// parameter like the gRPC health service, so load balancers take servers out
+// of rotation which drain or don't know a leader. It requires no
+// authorization.
+func (h *httpServer) health(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	st, ok := h.healthStatuses()[service]
+	if !ok {
+		h.writeError(w, status.Errorf(codes.NotFound, "unknown service %q", service))
+		return
+	}
+	code := http.StatusOK
+	if st != healthpb.HealthCheckResponse_SERVING {
+		code = http.StatusServiceUnavailable
+	}
+	h.write(w, code, &healthpb.HealthCheckResponse{Status: st})
+}
+
+func (h *httpServer) methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	slices.Sort(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	st := status.Newf(codes.Unimplemented, "method %s not allowed", r.Method)
+	h.write(w, http.StatusMethodNotAllowed, st.Proto())
+}
+
+func (h *httpServer) write(w http.ResponseWriter, code int, m proto.Message) {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		h.logger.Error("failed to marshal response", zap.Error(err))
+		code, b = http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		h.logger.Debug("failed to write response", zap.Error(err))
+	}
+}
+
+// writeError responds with the status of err, e.g. with 404 for
+// api.ErrOffsetOutOfRange and 503 with the leader for api.ErrNotLeader.
+func (h *httpServer) writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	h.write(w, httpStatus(st.Code()), st.Proto())
+}
+
+// httpStatus maps the codes of gRPC statuses to HTTP status codes.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	// api.ErrOffsetOutOfRange uses the HTTP code as its gRPC code
+	case codes.NotFound, http.StatusNotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		// the de facto code of nginx for requests the client gave up on
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func readJSON(r *http.Request, m proto.Message) error {
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPBodyBytes))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to read body: %v", err)
+	}
+	if err := protojson.Unmarshal(b, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid body: %v", err)
+	}
+	return nil
+}
+
+func parseUint(s, name string) (uint64, error) {
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s %q", name, s))
+	}
+	return v, nil
+}
+
+// authenticate returns the subject of the caller of r, identified by the
+// credentials of its connection and its Authorization header.
+func (h *httpServer) authenticate(r *http.Request) (string, error) {
+	return h.authenticator.Authenticate(r.Context(), auth.Credentials{
+		TLS:           r.TLS,
+		Authorization: r.Header.Values("Authorization"),
+	})
+}
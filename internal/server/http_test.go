@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPServer(t *testing.T) {
+	// arrange
+	setup := SetupTest(t, func(c *Config) { c.Drain = NewDrain() }, debug)
+	defer setup.Teardown()
+	root, baseURL := setupHTTPServer(t, setup.Server, config.RootClientCertFile, config.RootClientKeyFile)
+	nobody, _ := setupHTTPServer(t, setup.Server, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+
+	// act & assert
+	created := &api.CreateRecordResponse{}
+	code := doHTTP(t, root, http.MethodPost, baseURL+"/v1/records", `{"record": {"value": "Zm9v"}}`, created)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, uint64(0), created.Offset)
+
+	batch := &api.CreateBatchResponse{}
+	code = doHTTP(t, root, http.MethodPost, baseURL+"/v1/records/batch", `{"records": [{"value": "YmFy"}, {"value": "YmF6"}]}`, batch)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []uint64{1, 2}, batch.Offsets)
+
+	got := &api.GetRecordResponse{}
+	code = doHTTP(t, root, http.MethodGet, baseURL+"/v1/records/1?consistency=linearizable", "", got)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []byte("bar"), got.Record.Value)
+
+	read := &api.ReadRangeResponse{}
+	code = doHTTP(t, root, http.MethodGet, baseURL+"/v1/records?offset=1&max_records=5", "", read)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, read.Records, 2)
+	require.Equal(t, uint64(3), read.NextOffset)
+
+	// errors respond with their status
+	st := &spb.Status{}
+	code = doHTTP(t, root, http.MethodGet, baseURL+"/v1/records/3", "", st)
+	require.Equal(t, http.StatusNotFound, code)
+	outOfRange, ok := api.AsErrOffsetOutOfRange(status.FromProto(st).Err())
+	require.True(t, ok)
+	require.Equal(t, uint64(3), outOfRange.Offset)
+
+	code = doHTTP(t, nobody, http.MethodGet, baseURL+"/v1/records/0", "", st)
+	require.Equal(t, http.StatusForbidden, code)
+
+	code = doHTTP(t, root, http.MethodGet, baseURL+"/v1/records/foo", "", st)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code = doHTTP(t, root, http.MethodDelete, baseURL+"/v1/records", "", st)
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+
+	health := &healthpb.HealthCheckResponse{}
+	code = doHTTP(t, nobody, http.MethodGet, baseURL+"/v1/health", "", health)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+
+	setup.Config.Drain.Run(&cluster{})
+	code = doHTTP(t, nobody, http.MethodGet, baseURL+"/v1/health", "", health)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, health.Status)
+}
+
+func TestHTTPServerNotLeader(t *testing.T) {
+	// arrange
+	setup := SetupTest(t, func(c *Config) {
+		c.CommitLog = followerLog{CommitLog: c.CommitLog, leaderAddr: "localhost:8400"}
+	}, debug)
+	defer setup.Teardown()
+	root, baseURL := setupHTTPServer(t, setup.Server, config.RootClientCertFile, config.RootClientKeyFile)
+
+	// act
+	st := &spb.Status{}
+	code := doHTTP(t, root, http.MethodPost, baseURL+"/v1/records", `{"record": {"value": "Zm9v"}}`, st)
+
+	// assert
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	notLeader, ok := api.AsErrNotLeader(status.FromProto(st).Err())
+	require.True(t, ok)
+	require.Equal(t, "localhost:8400", notLeader.LeaderAddr)
+}
+
+func TestHTTPServerSharesForwarder(t *testing.T) {
+	// arrange
+	leader := SetupTest(t, nil, debug)
+	defer leader.Teardown()
+	peerTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	follower := SetupTest(t, func(c *Config) {
+		c.CommitLog = followerLog{CommitLog: c.CommitLog, leaderAddr: leader.LogServerAddr}
+		c.ForwardDialOptions = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(peerTLSConfig))}
+	}, debug)
+	defer follower.Teardown()
+	root, baseURL := setupHTTPServer(t, follower.Server, config.RootClientCertFile, config.RootClientKeyFile)
+
+	// act
+	res := &api.CreateRecordResponse{}
+	code := doHTTP(t, root, http.MethodPost, baseURL+"/v1/records", `{"record": {"value": "Zm9v"}}`, res)
+
+	// assert
+	require.Equal(t, http.StatusOK, code)
+	forwarder := follower.Server.srv.forwarder
+	forwarder.mu.Lock()
+	require.NotNil(t, forwarder.conn)
+	forwarder.mu.Unlock()
+
+	follower.Server.Stop()
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	require.True(t, forwarder.closed)
+}
+
+// setupHTTPServer serves the HTTP/JSON gateway of gsrv over TLS and returns a
+// client authenticated by the certificate and the URL of the server.
+func setupHTTPServer(t *testing.T, gsrv *GRPCServer, certFile, keyFile string) (*http.Client, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: l.Addr().String(),
+		Server:        true,
+	})
+	require.NoError(t, err)
+
+	srv := NewHTTPServer(gsrv)
+	go srv.Serve(tls.NewListener(l, serverTLSConfig))
+	t.Cleanup(func() { srv.Close() })
+
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+	t.Cleanup(client.CloseIdleConnections)
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	return client, fmt.Sprintf("https://localhost:%s", port)
+}
+
+// doHTTP sends body to url and reads the response into res.
+func doHTTP(t *testing.T, client *http.Client, method, url, body string, res proto.Message) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	proto.Reset(res)
+	require.NoError(t, protojson.Unmarshal(b, res), string(b))
+	return resp.StatusCode
+}
@@ -1,39 +0,0 @@
-package server
-
-import (
-	"fmt"
-	"sync"
-)
-
-var ErrOffsetNotFound = fmt.Errorf("offset not found")
-
-type Record struct {
-	Value  []byte `json:"value"`
-	Offset uint64 `json:"offset"`
-}
-
-type Log struct {
-	mtx     sync.Mutex
-	records []Record
-}
-
-func NewLog() *Log {
-	return &Log{}
-}
-
-func (l *Log) Append(record Record) (uint64, error) {
-	l.mtx.Lock()
-	defer l.mtx.Unlock()
-	record.Offset = uint64(len(l.records))
-	l.records = append(l.records, record)
-	return record.Offset, nil
-}
-
-func (l *Log) Read(offset uint64) (Record, error) {
-	l.mtx.Lock()
-	defer l.mtx.Unlock()
-	if offset >= uint64(len(l.records)) {
-		return Record{}, ErrOffsetNotFound
-	}
-	return l.records[offset], nil
-}
@@ -2,6 +2,10 @@ package server
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -9,6 +13,7 @@ import (
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal/auth"
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
@@ -19,70 +24,192 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
 	createAction string = "create"
 	getAction    string = "get"
+	// forwardAction permits servers to forward writes on behalf of others
+	forwardAction string = "forward"
+)
+
+// maxStreamBatch bounds the amount of pipelined requests CreateStream
+// coalesces into a single batch.
+const maxStreamBatch = 128
+
+// maxReadWait bounds the time a replica waits to hold the minimum offset of
+// a read before failing it.
+const maxReadWait = time.Second
+
+// ReadRange returns at most maxReadRangeRecords records and
+// maxReadRangeBytes of them per call, which also serve as the defaults.
+const (
+	maxReadRangeRecords = 1000
+	maxReadRangeBytes   = 1 << 20
 )
 
 type CommitLog interface {
 	Append(*api.Record) (uint64, error)
+	AppendBatch([]*api.Record) ([]uint64, error)
 	Read(uint64) (*api.Record, error)
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+	OffsetForTime(int64) (uint64, error)
+	ReadRange(start uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error)
+	Notify(off uint64) <-chan struct{}
+}
+
+// Barrierer is implemented by commit logs which replicate their records.
+// Barrier returns once the local log holds all records acknowledged to
+// writers, or fails with api.ErrNotLeader if the server isn't the leader.
+type Barrierer interface {
+	Barrier() error
 }
 
 type Authorizer interface {
 	Authorize(subject, action string) error
 }
 
+// Authenticator returns the subject of a caller, see auth.Authenticator.
+type Authenticator interface {
+	Authenticate(ctx context.Context, creds auth.Credentials) (string, error)
+}
+
 type GetServerer interface {
 	GetServers() ([]*api.Server, error)
 }
 
+// ServerWatcher is implemented by GetServerers which report changes of the
+// servers, so WatchServers streams them as they happen.
+type ServerWatcher interface {
+	// ServersChanged returns a channel which is closed by the next change of
+	// the servers or their leader.
+	ServersChanged() <-chan struct{}
+}
+
 type Config struct {
-	CommitLog   CommitLog
-	Authorizer  Authorizer
-	GetServerer GetServerer
+	CommitLog  CommitLog
+	Authorizer Authorizer
+	// Authenticator identifies the callers of the gRPC server and the HTTP
+	// gateway. It defaults to the common names of their certificates, with
+	// all other callers as the subject "".
+	Authenticator Authenticator
+	GetServerer   GetServerer
+	// ForwardDialOptions enable forwarding writes and linearizable reads
+	// which reach a follower to the leader. Without them followers fail
+	// them with api.ErrNotLeader.
+	ForwardDialOptions []grpc.DialOption
+	// Cluster enables the Admin service.
+	Cluster Cluster
+	// Drain takes the server out of service, see Drain.
+	Drain *Drain
+	// Metrics is served by the HTTP gateway at /metrics, e.g. a
+	// metrics.Handler. Like /v1/health, it requires no authorization.
+	Metrics http.Handler
+	// WebSocketOrigins are the origins, e.g. https://example.com, of the
+	// pages which may tail the log over WebSocket besides the ones served by
+	// the gateway itself. "*" allows all.
+	WebSocketOrigins []string
 }
 
 type grpcServer struct {
 	api.UnimplementedLogServer
 	*Config
+	forwarder     *forwarder
+	drain         *Drain
+	authenticator Authenticator
 }
 
 func newGRPCServer(config *Config) (*grpcServer, error) {
 	srv := &grpcServer{
-		Config: config,
+		Config:        config,
+		drain:         config.Drain,
+		authenticator: config.Authenticator,
+	}
+	if srv.drain == nil {
+		srv.drain = NewDrain()
+	}
+	if srv.authenticator == nil {
+		srv.authenticator = auth.Chain(auth.CommonName{}, auth.Anonymous(""))
+	}
+	if config.ForwardDialOptions != nil {
+		srv.forwarder = newForwarder(config.ForwardDialOptions)
 	}
 	return srv, nil
 }
 
+// close releases the resources of the server once it stopped serving.
+func (s *grpcServer) close() {
+	if s.forwarder == nil {
+		return
+	}
+	if err := s.forwarder.Close(); err != nil {
+		zap.L().Named("server").Warn("failed to close forwarder", zap.Error(err))
+	}
+}
+
 var _ api.LogServer = (*grpcServer)(nil)
 
 type subjectContextKey struct{}
 
-func authenticate(ctx context.Context) (context.Context, error) {
+type forwardedContextKey struct{}
+
+// authorizationMetadataKey carries the bearer tokens of callers.
+const authorizationMetadataKey = "authorization"
+
+// authenticate identifies the caller by the credentials of its connection and
+// metadata. Requests forwarded by another server are attributed to their
+// original caller instead.
+func (s *grpcServer) authenticate(ctx context.Context) (context.Context, error) {
 	peer, ok := peer.FromContext(ctx)
 	if !ok {
 		return ctx, status.New(codes.Unknown, "couldn't find peer info").Err()
 	}
-
-	if peer.AuthInfo == nil {
-		return context.WithValue(ctx, subjectContextKey{}, ""), nil
+	creds := auth.Credentials{
+		Authorization: metadata.ValueFromIncomingContext(ctx, authorizationMetadataKey),
+	}
+	if tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo); ok {
+		creds.TLS = &tlsInfo.State
+	}
+	subject, err := s.authenticator.Authenticate(ctx, creds)
+	if err != nil {
+		return ctx, err
 	}
-
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
 	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
-	return ctx, nil
+
+	forwardedFor := metadata.ValueFromIncomingContext(ctx, subjectMetadataKey)
+	if len(forwardedFor) == 0 {
+		return ctx, nil
+	}
+	if err = s.Authorizer.Authorize(subject, forwardAction); err != nil {
+		return ctx, err
+	}
+	ctx = context.WithValue(ctx, subjectContextKey{}, forwardedFor[0])
+	return context.WithValue(ctx, forwardedContextKey{}, true), nil
 }
 
 func subject(ctx context.Context) string {
 	return ctx.Value(subjectContextKey{}).(string)
 }
 
+// forwardTo returns the leader a request which failed with err is forwarded
+// to. Requests are forwarded once at most, so they can't go in circles while
+// the servers disagree on the leader.
+func (s *grpcServer) forwardTo(ctx context.Context, err error) (string, bool) {
+	notLeader, ok := err.(api.ErrNotLeader)
+	if !ok || s.forwarder == nil || notLeader.LeaderAddr == "" {
+		return "", false
+	}
+	if forwarded, _ := ctx.Value(forwardedContextKey{}).(bool); forwarded {
+		return "", false
+	}
+	return notLeader.LeaderAddr, true
+}
+
 func (s *grpcServer) Create(ctx context.Context, req *api.CreateRecordRequest) (*api.CreateRecordResponse, error) {
 	subject := subject(ctx)
 	err := s.Authorizer.Authorize(subject, getAction)
@@ -90,6 +217,9 @@ func (s *grpcServer) Create(ctx context.Context, req *api.CreateRecordRequest) (
 		return nil, err
 	}
 	offset, err := s.CommitLog.Append(req.Record)
+	if leader, ok := s.forwardTo(ctx, err); ok {
+		return s.forwarder.Create(ctx, leader, req)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +232,24 @@ func (s *grpcServer) Get(ctx context.Context, req *api.GetRecordRequest) (*api.G
 	if err != nil {
 		return nil, err
 	}
+
+	switch req.Consistency {
+	case api.ReadConsistency_READ_CONSISTENCY_MIN_OFFSET:
+		if err = s.waitFor(ctx, req.MinOffset); err != nil {
+			return nil, err
+		}
+	case api.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE:
+		if barrierer, ok := s.CommitLog.(Barrierer); ok {
+			err = barrierer.Barrier()
+			if leader, ok := s.forwardTo(ctx, err); ok {
+				return s.forwarder.Get(ctx, leader, req)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	rec, err := s.CommitLog.Read(req.GetOffset())
 	if err != nil {
 		return nil, err
@@ -110,54 +258,221 @@ func (s *grpcServer) Get(ctx context.Context, req *api.GetRecordRequest) (*api.G
 	return &api.GetRecordResponse{Record: rec}, nil
 }
 
+// waitFor waits up to maxReadWait for the log to hold off, so replicas which
+// lag behind briefly still serve reads of recent writes.
+func (s *grpcServer) waitFor(ctx context.Context, off uint64) error {
+	timer := time.NewTimer(maxReadWait)
+	defer timer.Stop()
+	for {
+		appended := s.CommitLog.Notify(off)
+		select {
+		case <-appended:
+			return nil
+		default:
+		}
+
+		select {
+		case <-appended:
+		case <-timer.C:
+			return api.ErrOffsetOutOfRange{Offset: off}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *grpcServer) CreateBatch(ctx context.Context, req *api.CreateBatchRequest) (*api.CreateBatchResponse, error) {
+	subject := subject(ctx)
+	err := s.Authorizer.Authorize(subject, createAction)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := s.CommitLog.AppendBatch(req.Records)
+	if leader, ok := s.forwardTo(ctx, err); ok {
+		return s.forwarder.CreateBatch(ctx, leader, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateBatchResponse{Offsets: offsets}, nil
+}
+
+// CreateStream appends the records of all requests which are already pipelined
+// by the client as one batch, responding with an offset per request.
 func (s *grpcServer) CreateStream(stream api.Log_CreateStreamServer) error {
+	if isClosed(s.drain.Draining()) {
+		return api.ErrDraining{}
+	}
+
+	reqs := make(chan *api.CreateRecordRequest, maxStreamBatch)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-stream.Context().Done():
+				recvErr <- stream.Context().Err()
+				return
+			}
+		}
+	}()
+
 	for {
-		req, err := stream.Recv()
-		if err != nil {
-			return err
+		var req *api.CreateRecordRequest
+		var ok bool
+		select {
+		case req, ok = <-reqs:
+		case <-s.drain.Ending():
+			return api.ErrDraining{}
+		}
+		if !ok {
+			return <-recvErr
 		}
 
-		res, err := s.Create(stream.Context(), req)
-		if err != nil {
-			return err
+		records := []*api.Record{req.Record}
+	coalesce:
+		for len(records) < maxStreamBatch {
+			select {
+			case req, ok := <-reqs:
+				if !ok {
+					break coalesce
+				}
+				records = append(records, req.Record)
+			default:
+				break coalesce
+			}
 		}
 
-		err = stream.Send(res)
+		res, err := s.CreateBatch(stream.Context(), &api.CreateBatchRequest{Records: records})
 		if err != nil {
 			return err
 		}
+
+		for _, offset := range res.Offsets {
+			err = stream.Send(&api.CreateRecordResponse{Offset: offset})
+			if err != nil {
+				return err
+			}
+		}
 	}
 }
 
+// GetStream sends the records from the offset of the client's request on and
+// then tails the log, waiting for new records until the stream is done. Each
+// further request moves the stream to its offset. Draining servers end the
+// stream with the offset to resume it at.
 func (s *grpcServer) GetStream(stream api.Log_GetStreamServer) error {
-	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
+	if isClosed(s.drain.Draining()) {
+		return api.ErrDraining{}
+	}
+
+	ctx := stream.Context()
+	reqs := make(chan *api.GetRecordRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
 			req, err := stream.Recv()
 			if err != nil {
-				return err
+				recvErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var req *api.GetRecordRequest
+	// appended is set while the stream waits for records at req's offset
+	var appended <-chan struct{}
+	for {
+		if req != nil && appended == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.drain.Ending():
+				return api.ErrDraining{ResumeOffset: req.Offset}
+			case req = <-reqs:
+			default:
 			}
 
-			res, err := s.Get(stream.Context(), req)
+			res, err := s.Get(ctx, req)
 			switch err.(type) {
 			case nil:
-			case api.ErrOffsetOutOfRange:
+				err = stream.Send(res)
+				if err != nil {
+					return err
+				}
+				// compacted offsets are skipped by the log, and the
+				// consistency of the request only applies to its first
+				// record, the others are tailed from the local log
+				req = &api.GetRecordRequest{Offset: res.Record.Offset + 1}
 				continue
+			case api.ErrOffsetOutOfRange:
+				lowest, lowErr := s.CommitLog.LowestOffset()
+				if lowErr == nil && req.Offset < lowest {
+					// the records were removed from the log
+					return err
+				}
+				appended = s.CommitLog.Notify(req.Offset)
 			default:
 				return err
 			}
+		}
 
-			err = stream.Send(res)
-			if err != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.drain.Ending():
+			return api.ErrDraining{ResumeOffset: req.GetOffset()}
+		case req = <-reqs:
+			appended = nil
+		case err := <-recvErr:
+			// a client which closed its side keeps receiving records
+			if err != io.EOF {
 				return err
 			}
-			req.Offset++
+			if req == nil {
+				return nil
+			}
+			recvErr = nil
+		case <-appended:
+			appended = nil
 		}
 	}
 }
 
+func (s *grpcServer) ReadRange(ctx context.Context, req *api.ReadRangeRequest) (*api.ReadRangeResponse, error) {
+	subject := subject(ctx)
+	err := s.Authorizer.Authorize(subject, getAction)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRecords := int(req.MaxRecords)
+	if maxRecords == 0 || maxRecords > maxReadRangeRecords {
+		maxRecords = maxReadRangeRecords
+	}
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 || maxBytes > maxReadRangeBytes {
+		maxBytes = maxReadRangeBytes
+	}
+
+	records, next, err := s.CommitLog.ReadRange(req.Offset, maxRecords, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ReadRangeResponse{Records: records, NextOffset: next}, nil
+}
+
 func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
 	servers, err := s.GetServerer.GetServers()
 	if err != nil {
@@ -166,7 +481,109 @@ func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest)
 	return &api.GetServersResponse{Servers: servers}, nil
 }
 
-func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+// WatchServers sends the servers and then again every time they change. Without
+// a ServerWatcher the servers are sent once and the stream stays idle.
+func (s *grpcServer) WatchServers(req *api.WatchServersRequest, stream api.Log_WatchServersServer) error {
+	if isClosed(s.drain.Draining()) {
+		return api.ErrDraining{}
+	}
+
+	watcher, _ := s.GetServerer.(ServerWatcher)
+	var sent []*api.Server
+	for {
+		var changed <-chan struct{}
+		if watcher != nil {
+			changed = watcher.ServersChanged()
+		}
+		servers, err := s.GetServerer.GetServers()
+		if err != nil {
+			return err
+		}
+		// changes may cancel out, e.g. if the same leader is elected again
+		if sent == nil || !slices.EqualFunc(sent, servers, func(a, b *api.Server) bool {
+			return proto.Equal(a, b)
+		}) {
+			if err := stream.Send(&api.GetServersResponse{Servers: servers}); err != nil {
+				return err
+			}
+			sent = servers
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.drain.Ending():
+			return api.ErrDraining{}
+		case <-changed:
+		}
+	}
+}
+
+func (s *grpcServer) GetOffsets(ctx context.Context, req *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	subject := subject(ctx)
+	err := s.Authorizer.Authorize(subject, getAction)
+	if err != nil {
+		return nil, err
+	}
+	lowest, err := s.CommitLog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	highest, err := s.CommitLog.HighestOffset()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetOffsetsResponse{
+		LowestOffset:  lowest,
+		HighestOffset: highest,
+	}, nil
+}
+
+func (s *grpcServer) GetOffsetForTime(ctx context.Context, req *api.GetOffsetForTimeRequest) (*api.GetOffsetForTimeResponse, error) {
+	subject := subject(ctx)
+	err := s.Authorizer.Authorize(subject, getAction)
+	if err != nil {
+		return nil, err
+	}
+	off, err := s.CommitLog.OffsetForTime(req.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetOffsetForTimeResponse{Offset: off}, nil
+}
+
+// GRPCServer serves the Log service, the health service and, given a
+// Cluster, the Admin service. Stopping it also stops updating the health
+// service and closes the connection its requests are forwarded to the leader
+// with.
+type GRPCServer struct {
+	*grpc.Server
+	srv *grpcServer
+	// stopHealth ends watchHealth
+	stopHealth chan struct{}
+	closeOnce  sync.Once
+}
+
+// Stop stops the server like grpc.Server.Stop.
+func (s *GRPCServer) Stop() {
+	s.Server.Stop()
+	s.close()
+}
+
+// GracefulStop stops the server like grpc.Server.GracefulStop.
+func (s *GRPCServer) GracefulStop() {
+	s.Server.GracefulStop()
+	s.close()
+}
+
+func (s *GRPCServer) close() {
+	s.closeOnce.Do(func() {
+		close(s.stopHealth)
+		s.srv.close()
+	})
+}
+
+func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*GRPCServer, error) {
 
 	logger := zap.L().Named("server")
 	zapOpts := []grpc_zap.Option{
@@ -180,8 +597,13 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		),
 	}
 
+	srv, err := newGRPCServer(config)
+	if err != nil {
+		return nil, err
+	}
+
 	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
-	err := view.Register(ocgrpc.DefaultServerViews...)
+	err = view.Register(ocgrpc.DefaultServerViews...)
 	if err != nil {
 		return nil, err
 	}
@@ -191,13 +613,13 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 			grpc_middleware.ChainStreamServer(
 				grpc_ctxtags.StreamServerInterceptor(),
 				grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-				grpc_auth.StreamServerInterceptor(authenticate),
+				grpc_auth.StreamServerInterceptor(srv.authenticate),
 			),
 		),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_ctxtags.UnaryServerInterceptor(),
 			grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			grpc_auth.UnaryServerInterceptor(authenticate),
+			grpc_auth.UnaryServerInterceptor(srv.authenticate),
 		)),
 		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 	}
@@ -206,14 +628,13 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 	gsrv := grpc.NewServer(opts...)
 
 	hsrv := health.NewServer()
-	hsrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	stopHealth := make(chan struct{})
+	go srv.watchHealth(hsrv, srv.updateHealth(hsrv), stopHealth)
 	healthpb.RegisterHealthServer(gsrv, hsrv)
 
-	srv, err := newGRPCServer(config)
-	if err != nil {
-		return nil, err
-	}
-
 	api.RegisterLogServer(gsrv, srv)
-	return gsrv, nil
+	if config.Cluster != nil {
+		api.RegisterAdminServer(gsrv, &adminServer{Config: config, drain: srv.drain})
+	}
+	return &GRPCServer{Server: gsrv, srv: srv, stopHealth: stopHealth}, nil
 }
@@ -3,12 +3,18 @@ package server
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	api "github.com/justagabriel/proglog/api/v1"
 	"github.com/justagabriel/proglog/internal"
+	"github.com/justagabriel/proglog/internal/auth"
 	"github.com/justagabriel/proglog/internal/config"
 	"github.com/justagabriel/proglog/internal/log"
 	"github.com/stretchr/testify/require"
@@ -18,6 +24,7 @@ import (
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -42,6 +49,14 @@ func TestServer(t *testing.T) {
 		"consume past log boundary fails":               testGetPastBoundary,
 		"create/get a stream succeeds":                  testCreateGetStream,
 		"unauthorized client is not served":             testUnauthorized,
+		"corrupt record is reported as data loss":       testGetCorruptRecord,
+		"get offsets returns the readable range":        testGetOffsets,
+		"get offset for time finds the first later one": testGetOffsetForTime,
+		"create batch returns contiguous offsets":       testCreateBatch,
+		"create stream coalesces pipelined requests":    testCreateStreamPipelined,
+		"read range returns batches and next offset":    testReadRange,
+		"get stream tails records as they are created":  testGetStreamTail,
+		"get with min offset waits for the offset":      testGetMinOffset,
 	}
 
 	for title, scenario := range scenarios {
@@ -165,6 +180,234 @@ func testCreateGetStream(t *testing.T, authorizedClient api.LogClient, unauthori
 	}
 }
 
+func testGetStreamTail(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getStream, err := authorizedClient.GetStream(ctx)
+	require.NoError(t, err)
+	err = getStream.Send(&api.GetRecordRequest{Offset: 0})
+	require.NoError(t, err)
+
+	received := make(chan *api.Record)
+	go func() {
+		defer close(received)
+		for {
+			res, err := getStream.Recv()
+			if err != nil {
+				return
+			}
+			received <- res.Record
+		}
+	}()
+
+	// act
+	for i := 0; i < 3; i++ {
+		_, err = authorizedClient.Create(ctx, &api.CreateRecordRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("hello world %d", i))},
+		})
+		require.NoError(t, err)
+
+		// assert
+		select {
+		case record := <-received:
+			require.Equal(t, uint64(i), record.Offset)
+		case <-time.After(time.Second):
+			t.Fatalf("record %d was not streamed", i)
+		}
+	}
+}
+
+func testGetMinOffset(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	get := func(minOffset uint64) (*api.GetRecordResponse, error) {
+		return authorizedClient.Get(ctx, &api.GetRecordRequest{
+			Offset:      0,
+			Consistency: api.ReadConsistency_READ_CONSISTENCY_MIN_OFFSET,
+			MinOffset:   minOffset,
+		})
+	}
+	type result struct {
+		res *api.GetRecordResponse
+		err error
+	}
+	waiting := make(chan result)
+	go func() {
+		res, err := get(1)
+		waiting <- result{res, err}
+	}()
+
+	// act
+	for i := 0; i < 2; i++ {
+		_, err := authorizedClient.Create(ctx, &api.CreateRecordRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("hello world %d", i))},
+		})
+		require.NoError(t, err)
+	}
+	_, timeoutErr := get(2)
+
+	// assert
+	got := <-waiting
+	require.NoError(t, got.err)
+	require.Equal(t, []byte("hello world 0"), got.res.Record.Value)
+	require.Equal(t, status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(timeoutErr))
+}
+
+func testCreateBatch(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	req := &api.CreateBatchRequest{}
+	for i := 0; i < 3; i++ {
+		req.Records = append(req.Records, &api.Record{
+			Value: []byte(fmt.Sprintf("hello world %d", i)),
+		})
+	}
+
+	// act
+	res, err := authorizedClient.CreateBatch(ctx, req)
+
+	// assert
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2}, res.Offsets)
+	for i, off := range res.Offsets {
+		getResp, err := authorizedClient.Get(ctx, &api.GetRecordRequest{Offset: off})
+		require.NoError(t, err)
+		require.Equal(t, req.Records[i].Value, getResp.Record.Value)
+	}
+}
+
+func testReadRange(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	req := &api.CreateBatchRequest{}
+	for i := 0; i < 5; i++ {
+		req.Records = append(req.Records, &api.Record{
+			Value: []byte(fmt.Sprintf("hello world %d", i)),
+		})
+	}
+	_, err := authorizedClient.CreateBatch(ctx, req)
+	require.NoError(t, err)
+
+	// act
+	first, err := authorizedClient.ReadRange(ctx, &api.ReadRangeRequest{Offset: 1, MaxRecords: 2})
+	require.NoError(t, err)
+	rest, err := authorizedClient.ReadRange(ctx, &api.ReadRangeRequest{Offset: first.NextOffset})
+	require.NoError(t, err)
+	_, pastEnd := authorizedClient.ReadRange(ctx, &api.ReadRangeRequest{Offset: rest.NextOffset})
+
+	// assert
+	requireOffsets := func(want []uint64, records []*api.Record) {
+		var got []uint64
+		for _, record := range records {
+			require.Equal(t, req.Records[record.Offset].Value, record.Value)
+			got = append(got, record.Offset)
+		}
+		require.Equal(t, want, got)
+	}
+	requireOffsets([]uint64{1, 2}, first.Records)
+	require.Equal(t, uint64(3), first.NextOffset)
+	requireOffsets([]uint64{3, 4}, rest.Records)
+	require.Equal(t, uint64(5), rest.NextOffset)
+	require.Equal(t, status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(pastEnd))
+}
+
+func testCreateStreamPipelined(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	stream, err := authorizedClient.CreateStream(ctx)
+	require.NoError(t, err)
+
+	// act
+	const count = 10
+	for i := 0; i < count; i++ {
+		err = stream.Send(&api.CreateRecordRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("hello world %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	// assert
+	for i := 0; i < count; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	require.NoError(t, stream.CloseSend())
+}
+
+func testGetCorruptRecord(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	createResp, err := authorizedClient.Create(ctx, &api.CreateRecordRequest{
+		Record: &api.Record{
+			Value: []byte("hello world"),
+		},
+	})
+	require.NoError(t, err)
+	_, err = authorizedClient.Get(ctx, &api.GetRecordRequest{Offset: createResp.Offset})
+	require.NoError(t, err)
+
+	clog := config.CommitLog.(*log.Log)
+	storeFile := filepath.Join(clog.Dir, fmt.Sprintf("%d.store", createResp.Offset))
+	f, err := os.OpenFile(storeFile, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("!"), fi.Size()-1)
+	require.NoError(t, err)
+
+	// act
+	getResp, err := authorizedClient.Get(ctx, &api.GetRecordRequest{Offset: createResp.Offset})
+
+	// assert
+	require.Nil(t, getResp)
+	require.Equal(t, codes.DataLoss, status.Code(err))
+}
+
+func testGetOffsets(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := authorizedClient.Create(ctx, &api.CreateRecordRequest{
+			Record: &api.Record{
+				Value: []byte("hello world"),
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// act
+	offsets, err := authorizedClient.GetOffsets(ctx, &api.GetOffsetsRequest{})
+
+	// assert
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.LowestOffset)
+	require.Equal(t, uint64(2), offsets.HighestOffset)
+}
+
+func testGetOffsetForTime(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
+	// arrange
+	ctx := context.Background()
+	for _, timestamp := range []int64{10, 20, 30} {
+		_, err := authorizedClient.Create(ctx, &api.CreateRecordRequest{
+			Record: &api.Record{
+				Value:     []byte("hello world"),
+				Timestamp: timestamp,
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// act
+	res, err := authorizedClient.GetOffsetForTime(ctx, &api.GetOffsetForTimeRequest{Timestamp: 15})
+
+	// assert
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Offset)
+}
+
 func testUnauthorized(t *testing.T, authorizedClient api.LogClient, unauthorizedClient api.LogClient, config *Config) {
 	const wantCode = codes.PermissionDenied
 
@@ -238,3 +481,170 @@ func TestServerRequiresClientTLSCert(t *testing.T) {
 	require.NoError(t, err, "the connection it self should work, only the auth should fail.")
 	require.NotEqual(t, clientConnection.GetState(), connectivity.Ready, "should be unable to connect due to missing TLS cert")
 }
+
+// followerLog fails writes like the log of a follower.
+type followerLog struct {
+	CommitLog
+	leaderAddr string
+}
+
+func (l followerLog) Append(*api.Record) (uint64, error) {
+	return 0, api.ErrNotLeader{LeaderAddr: l.leaderAddr}
+}
+
+func (l followerLog) AppendBatch([]*api.Record) ([]uint64, error) {
+	return nil, api.ErrNotLeader{LeaderAddr: l.leaderAddr}
+}
+
+func (l followerLog) Barrier() error {
+	return api.ErrNotLeader{LeaderAddr: l.leaderAddr}
+}
+
+func TestServerForwardsToLeader(t *testing.T) {
+	leader := SetupTest(t, nil, debug)
+	defer leader.Teardown()
+
+	peerTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+
+	scenarios := map[string]struct {
+		forwardDialOptions []grpc.DialOption
+		leaderAddr         string
+		forwarded          bool
+	}{
+		"writes are forwarded to the leader": {
+			forwardDialOptions: []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(peerTLSConfig))},
+			leaderAddr:         leader.LogServerAddr,
+			forwarded:          true,
+		},
+		"without forwarding the leader is reported": {
+			leaderAddr: leader.LogServerAddr,
+		},
+		"an unknown leader is reported": {
+			forwardDialOptions: []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(peerTLSConfig))},
+		},
+	}
+
+	for scenario, tc := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			// arrange
+			ctx := context.Background()
+			follower := SetupTest(t, func(c *Config) {
+				c.CommitLog = followerLog{CommitLog: c.CommitLog, leaderAddr: tc.leaderAddr}
+				c.ForwardDialOptions = tc.forwardDialOptions
+			}, debug)
+			defer follower.Teardown()
+
+			// act
+			createResp, createErr := follower.AuthorizedClient.Create(ctx, &api.CreateRecordRequest{
+				Record: &api.Record{Value: []byte("hello world")},
+			})
+			batchResp, batchErr := follower.AuthorizedClient.CreateBatch(ctx, &api.CreateBatchRequest{
+				Records: []*api.Record{{Value: []byte("hello again")}},
+			})
+			_, unauthorizedErr := follower.UnauthorizedClient.Create(ctx, &api.CreateRecordRequest{
+				Record: &api.Record{Value: []byte("hello world")},
+			})
+			getResp, getErr := follower.AuthorizedClient.Get(ctx, &api.GetRecordRequest{
+				Offset:      createResp.GetOffset(),
+				Consistency: api.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE,
+			})
+
+			// assert
+			require.Equal(t, codes.PermissionDenied, status.Code(unauthorizedErr))
+			if !tc.forwarded {
+				for _, err := range []error{createErr, batchErr, getErr} {
+					notLeader, ok := api.AsErrNotLeader(err)
+					require.True(t, ok)
+					require.Equal(t, tc.leaderAddr, notLeader.LeaderAddr)
+				}
+				return
+			}
+			require.NoError(t, createErr)
+			require.NoError(t, batchErr)
+			require.NoError(t, getErr)
+			require.Equal(t, []byte("hello world"), getResp.Record.Value)
+			getResp, err := leader.AuthorizedClient.Get(ctx, &api.GetRecordRequest{Offset: batchResp.Offsets[0]})
+			require.NoError(t, err)
+			require.Equal(t, []byte("hello again"), getResp.Record.Value)
+		})
+	}
+}
+
+func TestServerClosesForwarder(t *testing.T) {
+	// arrange
+	leader := SetupTest(t, nil, debug)
+	defer leader.Teardown()
+
+	server, err := NewGRPCServer(&Config{
+		CommitLog:          followerLog{leaderAddr: leader.LogServerAddr},
+		ForwardDialOptions: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+	})
+	require.NoError(t, err)
+	_, err = server.srv.forwarder.leader(leader.LogServerAddr)
+	require.NoError(t, err)
+	conn := server.srv.forwarder.conn
+
+	// act
+	server.Stop()
+
+	// assert
+	require.Equal(t, connectivity.Shutdown, conn.GetState())
+	_, err = server.srv.forwarder.leader(leader.LogServerAddr)
+	require.ErrorIs(t, err, errForwarderClosed)
+}
+
+// bearerTokens authenticates callers by the subjects of their tokens.
+type bearerTokens map[string]string
+
+func (b bearerTokens) Authenticate(_ context.Context, creds auth.Credentials) (string, error) {
+	if len(creds.Authorization) == 0 {
+		return "", auth.ErrNoCredentials
+	}
+	subject, ok := b[strings.TrimPrefix(creds.Authorization[0], "Bearer ")]
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return subject, nil
+}
+
+func TestServerAuthenticatesBearerTokens(t *testing.T) {
+	// arrange
+	setup := SetupTest(t, func(c *Config) {
+		c.Authenticator = auth.Chain(bearerTokens{"root-token": "root"}, auth.CommonName{})
+	}, debug)
+	defer setup.Teardown()
+	client, baseURL := setupHTTPServer(t, setup.Server, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	req := &api.CreateRecordRequest{Record: &api.Record{Value: []byte("hello world")}}
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), authorizationMetadataKey, "Bearer "+token)
+	}
+
+	// act & assert
+	// the token of root takes precedence over the certificate of nobody
+	_, err := setup.UnauthorizedClient.Create(withToken("root-token"), req)
+	require.NoError(t, err)
+
+	_, err = setup.UnauthorizedClient.Create(withToken("other-token"), req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = setup.UnauthorizedClient.Create(context.Background(), req)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	for token, want := range map[string]int{
+		"root-token":  http.StatusOK,
+		"other-token": http.StatusUnauthorized,
+	} {
+		httpReq, err := http.NewRequest(http.MethodGet, baseURL+"/v1/records/0", nil)
+		require.NoError(t, err)
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(httpReq)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, want, resp.StatusCode, token)
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// A tail reads up to tailBuffer records ahead of its client. Clients which
+// take longer than tailWriteTimeout to receive a message are disconnected,
+// and idle tails send a heartbeat every tailHeartbeat, so proxies keep their
+// connections open.
+const (
+	tailBuffer       = 256
+	tailWriteTimeout = 10 * time.Second
+	tailHeartbeat    = 15 * time.Second
+)
+
+// tail streams the records from the offset of the request on, and then the
+// ones appended later. Requests upgrading to WebSocket receive each record as
+// a JSON text message and empty heartbeats, all others as Server-Sent Events
+// with the offset of the record as their ID, so reconnecting EventSources
+// resume after their Last-Event-ID. WebSocket clients pass it as the
+// last_event_id query parameter instead. Errors, e.g. api.ErrDraining with
+// the offset to resume at on another server, end the stream with their
+// google.rpc.Status.
+func (h *httpServer) tail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	subject, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	// GetStream authorizes each record it reads, this fails early as well
+	if err := h.Authorizer.Authorize(subject, getAction); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if isClosed(h.drain.Draining()) {
+		h.writeError(w, api.ErrDraining{})
+		return
+	}
+	offset, err := tailOffset(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), subjectContextKey{}, subject)
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{
+			Handshake: h.checkOrigin,
+			Handler: func(ws *websocket.Conn) {
+				h.tailWebSocket(ctx, ws, offset)
+			},
+		}.ServeHTTP(w, r)
+		return
+	}
+	h.tailEvents(ctx, w, offset)
+}
+
+// checkOrigin refuses WebSocket handshakes of pages from origins other than
+// the gateway and WebSocketOrigins, which browsers would otherwise let tail
+// the log with the credentials of their user. Clients which aren't browsers
+// may leave out the Origin header.
+func (h *httpServer) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	config.Origin = u
+	if strings.EqualFold(u.Host, r.Host) || slices.Contains(h.WebSocketOrigins, "*") {
+		return nil
+	}
+	for _, allowed := range h.WebSocketOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+// tailOffset returns the offset following the Last-Event-ID of resuming
+// clients, or else the offset query parameter.
+func tailOffset(r *http.Request) (uint64, error) {
+	query := r.URL.Query()
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	if lastEventID != "" {
+		offset, err := parseUint(lastEventID, "Last-Event-ID")
+		return offset + 1, err
+	}
+	if query.Has("offset") {
+		return parseUint(query.Get("offset"), "offset")
+	}
+	return 0, nil
+}
+
+func (h *httpServer) tailEvents(ctx context.Context, w http.ResponseWriter, offset uint64) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(format string, args ...any) error {
+		err := rc.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send(": tailing from %d\n\n", offset); err != nil {
+		return
+	}
+	err := h.tailRecords(ctx, offset, func(record *api.Record) error {
+		if record == nil {
+			return send(": heartbeat\n\n")
+		}
+		b, err := protojson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return send("id: %d\ndata: %s\n\n", record.Offset, b)
+	})
+	if err != nil {
+		// "error" would mix with the connection errors of EventSources
+		b, _ := protojson.Marshal(status.Convert(err).Proto())
+		send("event: status\ndata: %s\n\n", b)
+	}
+}
+
+func (h *httpServer) tailWebSocket(ctx context.Context, ws *websocket.Conn, offset uint64) {
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// the client doesn't send messages, reading fails once it leaves
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		cancel()
+	}()
+
+	send := func(m proto.Message) error {
+		err := ws.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return websocket.Message.Send(ws, "")
+		}
+		b, err := protojson.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return websocket.Message.Send(ws, string(b))
+	}
+	err := h.tailRecords(ctx, offset, func(record *api.Record) error {
+		if record == nil {
+			return send(nil)
+		}
+		return send(record)
+	})
+	if err != nil {
+		send(status.Convert(err).Proto())
+	}
+}
+
+// tailRecords calls send with the records from offset on, and with nil while
+// no records arrive for tailHeartbeat. Records are read ahead into a buffer of
+// tailBuffer records, which bounds the records held for slow clients. It
+// returns the error ending the tail, or nil once ctx is done or send fails.
+func (h *httpServer) tailRecords(ctx context.Context, offset uint64, send func(*api.Record) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	records := make(chan *api.Record, tailBuffer)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		readErr <- h.readRecords(ctx, offset, records)
+	}()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case record, ok := <-records:
+			if !ok {
+				return <-readErr
+			}
+			err = send(record)
+			heartbeat.Reset(tailHeartbeat)
+		case <-heartbeat.C:
+			err = send(nil)
+		}
+		if err != nil {
+			h.logger.Debug("failed to send tail", zap.Error(err))
+			return nil
+		}
+	}
+}
+
+// readRecords reads the records from offset on into records, waiting for new
+// ones at the end of the log like GetStream.
+func (h *httpServer) readRecords(ctx context.Context, offset uint64, records chan<- *api.Record) error {
+	for {
+		res, err := h.Get(ctx, &api.GetRecordRequest{Offset: offset})
+		// either out or appended is set
+		var out chan<- *api.Record
+		var appended <-chan struct{}
+		switch err.(type) {
+		case nil:
+			out = records
+		case api.ErrOffsetOutOfRange:
+			lowest, lowErr := h.CommitLog.LowestOffset()
+			if lowErr == nil && offset < lowest {
+				// the records were removed from the log
+				return err
+			}
+			appended = h.CommitLog.Notify(offset)
+		default:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.drain.Ending():
+			return api.ErrDraining{ResumeOffset: offset}
+		case <-appended:
+		case out <- res.GetRecord():
+			// compacted offsets are skipped by the log
+			offset = res.Record.Offset + 1
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	api "github.com/justagabriel/proglog/api/v1"
+	"github.com/justagabriel/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestTailEvents(t *testing.T) {
+	// arrange
+	setup := SetupTest(t, func(c *Config) { c.Drain = NewDrain() }, debug)
+	defer setup.Teardown()
+	root, baseURL := setupHTTPServer(t, setup.Server, config.RootClientCertFile, config.RootClientKeyFile)
+	nobody, _ := setupHTTPServer(t, setup.Server, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	for _, value := range []string{"foo", "bar"} {
+		_, err := setup.AuthorizedClient.Create(context.Background(), &api.CreateRecordRequest{Record: &api.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+
+	// act & assert
+	events := tailEvents(t, root, baseURL+"/v1/tail?offset=1", "")
+	event := <-events
+	require.Equal(t, "1", event.id)
+	require.Equal(t, []byte("bar"), event.record.Value)
+
+	// records appended later are streamed as well
+	_, err := setup.AuthorizedClient.Create(context.Background(), &api.CreateRecordRequest{Record: &api.Record{Value: []byte("baz")}})
+	require.NoError(t, err)
+	event = <-events
+	require.Equal(t, "2", event.id)
+	require.Equal(t, []byte("baz"), event.record.Value)
+
+	// resuming clients continue after their last event
+	resumed := tailEvents(t, root, baseURL+"/v1/tail?offset=0", "1")
+	event = <-resumed
+	require.Equal(t, uint64(2), event.record.Offset)
+
+	st := &spb.Status{}
+	code := doHTTP(t, nobody, http.MethodGet, baseURL+"/v1/tail", "", st)
+	require.Equal(t, http.StatusForbidden, code)
+
+	// draining ends the streams with the offset to resume at
+	setup.Config.Drain.Run(&cluster{})
+	event = <-events
+	require.Equal(t, "status", event.name)
+	draining, ok := api.AsErrDraining(status.FromProto(event.status).Err())
+	require.True(t, ok)
+	require.Equal(t, uint64(3), draining.ResumeOffset)
+	_, ok = <-events
+	require.False(t, ok)
+}
+
+func TestTailWebSocket(t *testing.T) {
+	// arrange
+	setup := SetupTest(t, nil, debug)
+	defer setup.Teardown()
+	root, baseURL := setupHTTPServer(t, setup.Server, config.RootClientCertFile, config.RootClientKeyFile)
+	_, err := setup.AuthorizedClient.Create(context.Background(), &api.CreateRecordRequest{Record: &api.Record{Value: []byte("foo")}})
+	require.NoError(t, err)
+
+	wsConfig, err := websocket.NewConfig(strings.Replace(baseURL, "https", "wss", 1)+"/v1/tail?last_event_id=0", baseURL)
+	require.NoError(t, err)
+	wsConfig.TlsConfig = root.Transport.(*http.Transport).TLSClientConfig
+
+	// act
+	ws, err := websocket.DialConfig(wsConfig)
+	require.NoError(t, err)
+	defer ws.Close()
+	_, err = setup.AuthorizedClient.Create(context.Background(), &api.CreateRecordRequest{Record: &api.Record{Value: []byte("bar")}})
+	require.NoError(t, err)
+
+	// assert
+	var msg string
+	require.NoError(t, websocket.Message.Receive(ws, &msg))
+	record := &api.Record{}
+	require.NoError(t, protojson.Unmarshal([]byte(msg), record))
+	require.Equal(t, uint64(1), record.Offset)
+	require.Equal(t, []byte("bar"), record.Value)
+}
+
+func TestTailWebSocketChecksOrigin(t *testing.T) {
+	// arrange
+	setup := SetupTest(t, func(c *Config) {
+		c.WebSocketOrigins = []string{"https://allowed.example"}
+	}, debug)
+	defer setup.Teardown()
+	root, baseURL := setupHTTPServer(t, setup.Server, config.RootClientCertFile, config.RootClientKeyFile)
+
+	for origin, allowed := range map[string]bool{
+		baseURL:                   true,
+		"https://allowed.example": true,
+		"https://evil.example":    false,
+	} {
+		wsConfig, err := websocket.NewConfig(strings.Replace(baseURL, "https", "wss", 1)+"/v1/tail", origin)
+		require.NoError(t, err)
+		wsConfig.TlsConfig = root.Transport.(*http.Transport).TLSClientConfig
+
+		// act
+		ws, err := websocket.DialConfig(wsConfig)
+
+		// assert
+		if !allowed {
+			require.Error(t, err, origin)
+			continue
+		}
+		require.NoError(t, err, origin)
+		ws.Close()
+	}
+}
+
+type tailEvent struct {
+	id, name string
+	record   *api.Record
+	status   *spb.Status
+}
+
+// tailEvents sends the Server-Sent Events of url until the stream ends.
+func tailEvents(t *testing.T, client *http.Client, url, lastEventID string) <-chan tailEvent {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan tailEvent, 10)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event tailEvent
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				if event.name == "status" {
+					event.status = &spb.Status{}
+					protojson.Unmarshal([]byte(value), event.status)
+				} else {
+					event.record = &api.Record{}
+					protojson.Unmarshal([]byte(value), event.record)
+				}
+			case "":
+				if event.record != nil || event.status != nil {
+					events <- event
+				}
+				event = tailEvent{}
+			}
+		}
+	}()
+	return events
+}
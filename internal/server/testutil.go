@@ -14,6 +14,7 @@ import (
 	"go.opencensus.io/examples/exporter"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // LogServerTestSetup contains all entities necessary to create a test for a LogServer.
@@ -24,9 +25,20 @@ type LogServerTestSetup struct {
 	// UnauthorizedClient is an authenicated grpc client, unable to communicate with the created server.
 	UnauthorizedClient api.LogClient
 
+	// AuthorizedAdminClient and UnauthorizedAdminClient are clients of the Admin service, which is
+	// only served if the config has a Cluster.
+	AuthorizedAdminClient   api.AdminClient
+	UnauthorizedAdminClient api.AdminClient
+
+	// HealthClient is a client of the gRPC health service of the server.
+	HealthClient healthpb.HealthClient
+
 	// Config represents internal LogServer entities.
 	Config *Config
 
+	// Server is the gRPC server, whose HTTP gateway shares its forwarder and drain.
+	Server *GRPCServer
+
 	// Teardown will release all resources bound to the test server instance.
 	Teardown func()
 
@@ -91,6 +103,7 @@ func SetupTest(t *testing.T, fn func(*Config), isDebugMode *bool) LogServerTestS
 	}
 	server, err := NewGRPCServer(setup.Config, grpc.Creds(serverCreds))
 	require.NoError(t, err)
+	setup.Server = server
 
 	go func() {
 		server.Serve(listener)
@@ -118,6 +131,9 @@ func SetupTest(t *testing.T, fn func(*Config), isDebugMode *bool) LogServerTestS
 
 	setup.AuthorizedClient = rootClient
 	setup.UnauthorizedClient = nobodyClient
+	setup.AuthorizedAdminClient = api.NewAdminClient(rootConn)
+	setup.UnauthorizedAdminClient = api.NewAdminClient(nobodyConn)
+	setup.HealthClient = healthpb.NewHealthClient(rootConn)
 	setup.Teardown = func() {
 		server.Stop()
 		rootConn.Close()